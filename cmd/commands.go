@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/TheSgtPepper23/GreenLibrary/db"
+	"github.com/TheSgtPepper23/GreenLibrary/jobs"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+func runCommand(args []string, conn *pgxpool.Pool) error {
+	switch args[0] {
+	case "migrate-covers":
+		return commandMigrateCovers(args[1:], conn)
+	case "gc-images":
+		return commandGCImages(args[1:], conn)
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
+
+// Copies every image from one storage backend to another and points the books to the new URLs,
+// e.g. migrate-covers -from local -to s3. With -prefix only the images whose key starts with it are moved
+func commandMigrateCovers(args []string, conn *pgxpool.Pool) error {
+	flags := flag.NewFlagSet("migrate-covers", flag.ContinueOnError)
+	from := flags.String("from", "local", "backend where the images are stored (local or s3)")
+	to := flags.String("to", "s3", "backend where the images will be copied (local or s3)")
+	prefix := flags.String("prefix", "", "only move the images whose key starts with this prefix")
+	deleteSource := flags.Bool("delete", false, "delete the images from the source once copied")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *from == *to {
+		return fmt.Errorf("the source and the target are the same backend")
+	}
+
+	source, err := services.NewBlobStore(*from)
+	if err != nil {
+		return err
+	}
+	target, err := services.NewBlobStore(*to)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	copied, skipped := 0, 0
+	err = source.List(ctx, *prefix, func(blob services.BlobInfo) error {
+		exists, err := target.Exists(ctx, blob.Key)
+		if err != nil {
+			return err
+		}
+		if exists {
+			skipped++
+			return nil
+		}
+
+		data, err := source.Get(ctx, blob.Key)
+		if err != nil {
+			return err
+		}
+		if err := target.Put(ctx, blob.Key, data, services.ContentTypeByKey(blob.Key)); err != nil {
+			return err
+		}
+		copied++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d images copied, %d already in the target\n", copied, skipped)
+
+	//the keys are the same in both backends, so only the base of the URLs changes. The prefix is kept in
+	//the bases so the images that were not copied keep pointing to the source
+	updated, err := db.NewSQLBookContext(conn).RewriteCoverURLs(source.URL(*prefix), target.URL(*prefix))
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%d books and users updated\n", updated)
+
+	if *deleteSource {
+		deleted := 0
+		err = source.List(ctx, *prefix, func(blob services.BlobInfo) error {
+			deleted++
+			return source.Delete(ctx, blob.Key)
+		})
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%d images deleted from the source\n", deleted)
+	}
+
+	return nil
+}
+
+// Deletes the images that are not used by any book or user, e.g. gc-images -dry-run
+func commandGCImages(args []string, conn *pgxpool.Pool) error {
+	flags := flag.NewFlagSet("gc-images", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only report the orphans and missing images")
+	grace := flags.Duration("grace", jobs.GCGrace(), "orphans newer than this are kept")
+	maxOrphans := flags.Float64("max-orphans", jobs.GCMaxOrphanRate(), "share of the images that can be deleted, 1 removes the limit")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	store, err := services.NewBlobStoreFromEnv()
+	if err != nil {
+		return err
+	}
+
+	report, err := jobs.CollectImages(context.Background(), db.NewSQLBookContext(conn), store, *grace, *maxOrphans, *dryRun)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range report.Orphans {
+		fmt.Println("orphan:", key)
+	}
+	for _, url := range report.Missing {
+		fmt.Println("missing:", url)
+	}
+	fmt.Printf("%d images scanned, %d orphans (%d inside the grace period), %d deleted, %d missing\n",
+		report.Scanned, len(report.Orphans), report.Recent, report.Deleted, len(report.Missing))
+	return nil
+}
@@ -2,20 +2,46 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
-	"path/filepath"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
+	"github.com/TheSgtPepper23/GreenLibrary/db"
 	"github.com/TheSgtPepper23/GreenLibrary/models"
 	"github.com/TheSgtPepper23/GreenLibrary/services"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/jackc/pgx/v5"
 	"github.com/labstack/echo/v4"
 )
 
+// Rejects the tokens without the admin claim, must run after the JWT middleware
+func RequireAdmin(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		user, ok := c.Get("user").(*jwt.Token)
+		if !ok {
+			return echo.ErrUnauthorized
+		}
+		claims, ok := user.Claims.(jwt.MapClaims)
+		if !ok || claims["has"] != true {
+			return echo.ErrForbidden
+		}
+		return next(c)
+	}
+}
+
+const smartCollectionMessage = "Los libros de una colección inteligente dependen de sus reglas"
+
+// las colecciones con reglas son inteligentes, sus libros se calculan con las reglas
 func HandlerCreateCollection(c echo.Context) error {
 	dbContext := c.Get("dbContext").(*DatabaseContext)
 	data := new(models.Collection)
@@ -27,8 +53,7 @@ func HandlerCreateCollection(c echo.Context) error {
 	err := dbContext.CollDB.CreateCollection(data)
 
 	if err != nil {
-		fmt.Println(err.Error())
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+		return collectionError(err)
 	}
 
 	return c.JSON(200, data)
@@ -45,13 +70,26 @@ func HandlerUpdateCollection(c echo.Context) error {
 	err := dbContext.CollDB.UpdateCollection(data)
 
 	if err != nil {
-		fmt.Println(err.Error())
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+		return collectionError(err)
 	}
 
 	return c.JSON(200, data)
 }
 
+func collectionError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidRules):
+		return echo.NewHTTPError(http.StatusBadRequest, "Las reglas de la colección no son válidas: "+err.Error())
+	case errors.Is(err, db.ErrCollectionKind):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "Una colección no puede cambiar entre manual e inteligente")
+	case errors.Is(err, db.ErrSmartCollection):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, smartCollectionMessage)
+	default:
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+	}
+}
+
 func HandlerGetCollections(c echo.Context) error {
 	dbContext := c.Get("dbContext").(*DatabaseContext)
 	stringID := c.Param("userID")
@@ -90,8 +128,7 @@ func HandlerCreateNewBook(c echo.Context) error {
 		if err.Error() == "book already read" {
 			return echo.NewHTTPError(http.StatusUnprocessableEntity, "El libro ya está marcado como leído")
 		}
-		fmt.Println(err.Error())
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+		return collectionError(err)
 	}
 	return c.JSON(200, data)
 }
@@ -112,64 +149,223 @@ func HandlerUpdateBook(c echo.Context) error {
 	return c.JSON(200, data)
 }
 
-// tiene los params ammount, page, y order
+// PUT /book/reorder, acomoda los libros de una colección para el orden manual
+func HandlerReorderBooks(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	data := new(models.ReorderRequest)
+	if err := c.Bind(data); err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrBadRequest
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	err := dbContext.BookDb.ReorderBooks(data, claims["userKey"].(string))
+	switch {
+	case err == nil:
+		return c.JSON(200, data)
+	case errors.Is(err, pgx.ErrNoRows):
+		return echo.NewHTTPError(http.StatusNotFound, "No existe la colección")
+	case errors.Is(err, db.ErrInvalidMove):
+		return echo.NewHTTPError(http.StatusBadRequest, "Cada movimiento necesita el libro y solo uno de before o after")
+	case errors.Is(err, db.ErrBookNotInCollection):
+		return echo.NewHTTPError(http.StatusBadRequest, "El libro no se encuentra en la colección")
+	default:
+		return collectionError(err)
+	}
+}
+
+// tiene los params ammount, page, sort y seed. sort es una lista de campos separados por comas, con - para el orden
+// descendente (author,-rating). El orden aleatorio devuelve su semilla en X-Sort-Seed para pedir las siguientes páginas.
+// order es el entero de las primeras versiones y solo se usa si no viene sort
 func HandlerGetCollectonBooks(c echo.Context) error {
 	dbContext := c.Get("dbContext").(*DatabaseContext)
 	stringID := c.Param("collection")
 	ammout := c.QueryParam("ammount")
 	page := c.QueryParam("page")
-	order := c.QueryParam("order")
 
-	results, err := services.StringsToInts(ammout, page, order)
+	results, err := services.StringsToInts(ammout, page)
 	if err != nil {
 		fmt.Println(err.Error())
 		return echo.ErrBadRequest
 	}
 
-	books, err := dbContext.BookDb.GetBooksOfCollection(stringID, results[0], results[1], models.OrderOption(results[2]))
+	sort, err := services.ParseSort(c.QueryParam("sort"), c.QueryParam("seed"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "El orden no es válido: "+err.Error())
+	}
+	if order := c.QueryParam("order"); order != "" && c.QueryParam("sort") == "" {
+		legacy, err := strconv.Atoi(order)
+		if err != nil {
+			return echo.ErrBadRequest
+		}
+		sort = models.OrderOption(legacy).Sort()
+	}
+
+	books, err := dbContext.BookDb.GetBooksOfCollection(stringID, results[0], results[1], sort)
 	if err != nil {
 		fmt.Println(err.Error())
 		return echo.ErrNotFound
 	}
+	if sort.Has(models.SortRandom) {
+		c.Response().Header().Set("X-Sort-Seed", strconv.FormatInt(sort.Seed, 10))
+	}
 	return c.JSON(200, books)
 }
 
+// búsqueda original, recibe el título en el cuerpo y devuelve solo la primera página
 func HandlerSearchBook(c echo.Context) error {
-	dbContext := c.Get("dbContext").(*DatabaseContext)
 	data := make(map[string]string)
 	if err := c.Bind(&data); err != nil {
 		fmt.Println(err.Error())
 		return echo.ErrBadRequest
 	}
 
-	results := make([]models.Book, 0)
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	errChan := make(chan error, 2)
+	query := models.SearchQuery{Query: data["title"], Page: 1, Limit: models.DefaultSearchLimit}
+	page, err := searchCatalog(c, query)
+	if err != nil {
+		return err
+	}
 
-	start := time.Now()
-	wg.Add(2)
-	go services.SearchBook(data["title"], &results, &wg, &mu, errChan)
-	go dbContext.BookDb.SearchBookLocally(data["title"], &results, &wg, &mu, errChan)
+	//la respuesta es solo la lista, las fuentes que fallaron van en una cabecera
+	if page.Degraded {
+		failed := make([]string, 0, len(page.FailedSources))
+		for _, source := range page.FailedSources {
+			failed = append(failed, source.Provider)
+		}
+		c.Response().Header().Set("X-Failed-Sources", strings.Join(failed, ","))
+	}
+	return c.JSON(200, page.Results)
+}
 
-	wg.Wait()
-	close(errChan)
-	fmt.Printf("total : %v", time.Since(start).Milliseconds())
+// GET /book/search?q=&title=&author=&isbn=&yearFrom=&yearTo=&language=&subject=&page=&limit=
+func HandlerSearchCatalog(c echo.Context) error {
+	query, err := parseSearchQuery(c)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrBadRequest
+	}
+	if !query.HasTerms() {
+		return echo.NewHTTPError(http.StatusBadRequest, "Es necesario indicar qué buscar")
+	}
+
+	page, err := searchCatalog(c, query)
+	if err != nil {
+		return err
+	}
+	return c.JSON(200, page)
+}
+
+func parseSearchQuery(c echo.Context) (models.SearchQuery, error) {
+	query := models.SearchQuery{
+		Query:    strings.TrimSpace(c.QueryParam("q")),
+		Title:    strings.TrimSpace(c.QueryParam("title")),
+		Author:   strings.TrimSpace(c.QueryParam("author")),
+		ISBN:     strings.ReplaceAll(strings.TrimSpace(c.QueryParam("isbn")), "-", ""),
+		Language: strings.TrimSpace(c.QueryParam("language")),
+		Subject:  strings.TrimSpace(c.QueryParam("subject")),
+		Page:     1,
+		Limit:    models.DefaultSearchLimit,
+	}
+
+	//los parámetros numéricos son opcionales
+	numbers := []struct {
+		name   string
+		target *int
+	}{
+		{"yearFrom", &query.YearFrom},
+		{"yearTo", &query.YearTo},
+		{"page", &query.Page},
+		{"limit", &query.Limit},
+	}
+	for _, number := range numbers {
+		value := c.QueryParam(number.name)
+		if value == "" {
+			continue
+		}
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			return query, fmt.Errorf("invalid %s: %q", number.name, value)
+		}
+		*number.target = parsed
+	}
+
+	if query.Page < 1 {
+		return query, errors.New("the page starts at 1")
+	}
+	if query.Limit < 1 || query.Limit > models.MaxSearchLimit {
+		return query, fmt.Errorf("the limit goes from 1 to %d", models.MaxSearchLimit)
+	}
+	if query.YearFrom > 0 && query.YearTo > 0 && query.YearFrom > query.YearTo {
+		return query, errors.New("the year range is reversed")
+	}
+
+	return query, nil
+}
+
+// busca en todos los proveedores del catálogo, une sus resultados y los ordena
+func searchCatalog(c echo.Context, query models.SearchQuery) (*models.SearchPage, error) {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
 
-	hasError := false
-	for err := range errChan {
+	results := dbContext.Catalog.Search(c.Request().Context(), query)
+	page := &models.SearchPage{
+		Page:      query.Page,
+		Limit:     query.Limit,
+		Providers: make(map[string]int),
+		Offline:   dbContext.Catalog.Offline(),
+	}
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			fmt.Printf("%s: %s\n", result.Provider, result.Err.Error())
+			failed++
+			page.FailedSources = append(page.FailedSources, models.FailedSource{
+				Provider: result.Provider,
+				Reason:   services.FailureReason(result.Err),
+			})
+			continue
+		}
+		page.Providers[result.Provider] = result.Total
+		page.Total = max(page.Total, result.Total)
+	}
+
+	hits := services.MergeResults(results)
+	if failed > 0 && len(hits) == 0 {
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, page.FailedSources)
+	}
+	page.Degraded = failed > 0
+
+	markShelved(c, dbContext, hits)
+	page.Results = services.RankHits(query.Text(), hits)
+
+	if text := query.Text(); text != "" && query.Page == 1 && dbContext.BookDb.FewResults(len(page.Results)) {
+		suggestion, err := dbContext.BookDb.CatalogSpellingSuggestion(text)
 		if err != nil {
 			fmt.Println(err.Error())
-			hasError = true
-			break
 		}
+		page.DidYouMean = suggestion
 	}
+	return page, nil
+}
 
-	if hasError && len(results) == 0 {
-		return echo.ErrServiceUnavailable
-	}
+// marca los resultados que ya están en alguna colección del usuario, si falla se dejan sin marcar
+func markShelved(c echo.Context, dbContext *DatabaseContext, hits []services.SearchHit) {
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
 
-	return c.JSON(200, results)
+	keys := make([]string, 0, len(hits))
+	for _, hit := range hits {
+		keys = append(keys, hit.Book.Key)
+	}
+	shelved, err := dbContext.BookDb.ShelvedKeys(claims["userKey"].(string), keys)
+	if err != nil {
+		fmt.Println(err.Error())
+		return
+	}
+	for i := range hits {
+		hits[i].Book.Shelved = shelved[hits[i].Book.Key]
+	}
 }
 
 func HandlerRemoveFromCollection(c echo.Context) error {
@@ -182,8 +378,7 @@ func HandlerRemoveFromCollection(c echo.Context) error {
 
 	err := dbContext.BookDb.RemoveBookFromCollection(data)
 	if err != nil {
-		fmt.Println(err.Error())
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+		return collectionError(err)
 	}
 	return c.JSON(200, data)
 }
@@ -201,8 +396,7 @@ func HandlerMoveBook(c echo.Context) error {
 
 	err := dbContext.BookDb.MoveBook(data, claims["userKey"].(string))
 	if err != nil {
-		fmt.Println(err.Error())
-		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+		return collectionError(err)
 	}
 
 	return c.JSON(200, data)
@@ -229,6 +423,345 @@ func HandlerSearchUserBook(c echo.Context) error {
 	return c.JSON(200, result)
 }
 
+// GET /book/suggest?q=, autocompletado con los libros guardados, primero los que el usuario tiene en sus colecciones
+func HandlerSuggestBooks(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	query := services.SuggestKey(c.QueryParam("q"))
+	if utf8.RuneCountInString(query) < services.MinSuggestLength {
+		return c.JSON(200, []models.Suggestion{})
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	userKey := claims["userKey"].(string)
+
+	suggestions, found := dbContext.SuggestCache.Get(userKey, query)
+	if !found {
+		//una sugerencia que tarda no le sirve a nadie, el usuario ya escribió otra letra
+		ctx, cancel := context.WithTimeout(c.Request().Context(), time.Millisecond*500)
+		defer cancel()
+
+		var (
+			complete bool
+			err      error
+		)
+		suggestions, complete, err = dbContext.BookDb.SuggestBooks(ctx, query, userKey, services.SuggestLimit)
+		if err != nil {
+			fmt.Println(err.Error())
+			return echo.ErrServiceUnavailable
+		}
+		dbContext.SuggestCache.Put(userKey, query, suggestions, complete)
+	}
+
+	c.Response().Header().Set(echo.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(dbContext.SuggestCache.TTL().Seconds())))
+	c.Response().Header().Set(echo.HeaderVary, echo.HeaderAuthorization)
+	return c.JSON(200, suggestions)
+}
+
+// recibe la foto "photo" con uno o varios códigos de barras de libros y busca cada ISBN en el catálogo
+func HandlerScanBarcodes(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	data, err := readUploadedImage(c, "photo")
+	if err != nil {
+		return err
+	}
+
+	barcodes, err := services.ScanBarcodes(data)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "La imagen no es válida")
+	}
+	if len(barcodes) > maxScannedBarcodes {
+		barcodes = barcodes[:maxScannedBarcodes]
+	}
+
+	results := make([]models.ScannedBarcode, len(barcodes))
+	var wg sync.WaitGroup
+	//pocas búsquedas a la vez para no saturar los catálogos externos
+	limit := make(chan struct{}, 4)
+	for i, barcode := range barcodes {
+		results[i] = models.ScannedBarcode{Code: barcode.Code, Rows: barcode.Rows, Status: models.ScanNotISBN}
+		if !services.IsBooklandEAN(barcode.Code) {
+			continue
+		}
+
+		wg.Add(1)
+		go func(result *models.ScannedBarcode) {
+			defer wg.Done()
+			limit <- struct{}{}
+			defer func() { <-limit }()
+
+			book, err := lookupISBN(c, dbContext, result.Code)
+			switch {
+			case err != nil:
+				fmt.Println(err.Error())
+				result.Status = models.ScanFailed
+			case book == nil:
+				result.Status = models.ScanNotFound
+			default:
+				result.Status = models.ScanFound
+				result.Book = book
+			}
+		}(&results[i])
+	}
+	wg.Wait()
+
+	return c.JSON(http.StatusOK, results)
+}
+
+// las fotos de una estantería pueden tener muchos códigos, más allá de este número se ignoran
+const maxScannedBarcodes = 20
+
+// busca un ISBN en todos los proveedores, prefiere el libro que tiene exactamente ese ISBN
+func lookupISBN(c echo.Context, dbContext *DatabaseContext, isbn string) (*models.Book, error) {
+	results := dbContext.Catalog.Search(c.Request().Context(), models.SearchQuery{ISBN: isbn, Page: 1, Limit: 5})
+	failed := 0
+	for _, result := range results {
+		if result.Err != nil {
+			failed++
+		}
+	}
+
+	hits := services.MergeResults(results)
+	if len(hits) == 0 {
+		if failed > 0 {
+			return nil, fmt.Errorf("the catalog could not be searched for %s", isbn)
+		}
+		return nil, nil
+	}
+
+	markShelved(c, dbContext, hits)
+	books := services.RankHits("", hits)
+	book := books[0]
+	for _, candidate := range books {
+		if candidate.ISBN == isbn {
+			book = candidate
+			break
+		}
+	}
+	book.ISBN = isbn
+	return &book, nil
+}
+
+// GET /book/search/user?q=&collectionID=, además de los libros sugiere una corrección si encuentra pocos
+func HandlerSearchUserBooks(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	searchTerm := strings.TrimSpace(c.QueryParam("q"))
+	if searchTerm == "" {
+		return echo.ErrBadRequest
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	userKey := claims["userKey"].(string)
+	collectionID := c.QueryParam("collectionID")
+
+	books, err := dbContext.BookDb.SearchUserBooks(searchTerm, collectionID, userKey)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrNotFound
+	}
+
+	result := models.UserSearchResult{Results: *books}
+	if dbContext.BookDb.FewResults(len(*books)) {
+		//sin sugerencia la búsqueda sigue siendo válida
+		result.DidYouMean, err = dbContext.BookDb.UserSpellingSuggestion(searchTerm, collectionID, userKey)
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+	}
+
+	return c.JSON(200, result)
+}
+
+// recibe el archivo "file" generado por la herramienta de exportación de Goodreads, con el param dryRun
+// solo se muestra lo que se crearía
+func HandlerImportGoodreads(c echo.Context) error {
+	return importLibrary(c, services.ParseGoodreadsCSV, "El archivo no es una exportación válida de Goodreads")
+}
+
+// igual que HandlerImportGoodreads pero con la exportación de The StoryGraph
+func HandlerImportStoryGraph(c echo.Context) error {
+	return importLibrary(c, services.ParseStoryGraphCSV, "El archivo no es una exportación válida de The StoryGraph")
+}
+
+func importLibrary(c echo.Context, parse func(io.Reader) ([]models.ImportEntry, error), invalidMessage string) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	dryRun := c.QueryParam("dryRun") == "true"
+
+	file, err := c.FormFile("file")
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrBadRequest
+	}
+
+	src, err := file.Open()
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrBadRequest
+	}
+	defer src.Close()
+
+	entries, err := parse(src)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, invalidMessage)
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	userKey := claims["userKey"].(string)
+
+	//la vista previa no escribe nada, por lo que se responde de inmediato
+	if dryRun {
+		report, err := dbContext.ImportDB.ImportEntries(c.Request().Context(), entries, userKey, true)
+		if err != nil {
+			fmt.Println(err.Error())
+			return echo.ErrInternalServerError
+		}
+		return c.JSON(200, report)
+	}
+
+	job, err := dbContext.JobDB.Enqueue(models.JobImportLibrary, models.ImportJobPayload{UserID: userKey, Entries: entries}, userKey)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// tamaño máximo de una lista de ISBN, suficiente para el límite de services.MaxISBNList
+const maxISBNListSize = 1 << 20
+
+// POST /book/import/isbns?collectionID=, la lista puede enviarse como texto en el cuerpo o como el archivo "file",
+// con un ISBN por línea. Los ISBN inválidos se reportan de inmediato y el resto se busca en segundo plano
+func HandlerImportISBNs(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	collectionID := c.QueryParam("collectionID")
+	if collectionID == "" {
+		collectionID = c.FormValue("collectionID")
+	}
+	if collectionID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Es necesario indicar la colección")
+	}
+
+	var src io.Reader
+	if file, err := c.FormFile("file"); err == nil {
+		opened, err := file.Open()
+		if err != nil {
+			fmt.Println(err.Error())
+			return echo.ErrBadRequest
+		}
+		defer opened.Close()
+		src = opened
+	} else {
+		src = c.Request().Body
+	}
+
+	entries, rejected, err := services.ParseISBNList(io.LimitReader(src, maxISBNListSize))
+	if errors.Is(err, services.ErrTooManyISBNs) {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("La lista debe tener como máximo %d ISBN", services.MaxISBNList))
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "No es posible leer la lista de ISBN")
+	}
+	if len(entries) == 0 {
+		return c.JSON(http.StatusBadRequest, models.ISBNImportReport{
+			CollectionID: collectionID,
+			Invalid:      len(rejected),
+			Results:      rejected,
+		})
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	userKey := claims["userKey"].(string)
+
+	collection, err := dbContext.CollDB.GetCollection(collectionID)
+	if err != nil || collection.OwnerID != userKey {
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+		return echo.NewHTTPError(http.StatusNotFound, "No existe la colección")
+	}
+	if collection.Smart {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, smartCollectionMessage)
+	}
+
+	job, err := dbContext.JobDB.Enqueue(models.JobImportISBNs, models.ISBNImportJobPayload{
+		UserID:       userKey,
+		CollectionID: collectionID,
+		Entries:      entries,
+		Rejected:     rejected,
+	}, userKey)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "No es posible realizar la operación")
+	}
+	return c.JSON(http.StatusAccepted, job)
+}
+
+// los usuarios solo pueden consultar sus propios trabajos, como las importaciones
+func HandlerGetUserJob(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	job, err := dbContext.JobDB.GetJob(c.Param("jobID"))
+	if err != nil || job.OwnerID != claims["userKey"].(string) {
+		if err != nil {
+			fmt.Println(err.Error())
+		}
+		return echo.ErrNotFound
+	}
+	return c.JSON(200, job)
+}
+
+// tiene el param format que puede ser csv, json o goodreads
+func HandlerExportLibrary(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	response := c.Response()
+
+	exporter, err := services.NewExporter(c.QueryParam("format"), response)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrBadRequest
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+
+	//the status is sent with the first entry, so a query that fails from the start still gets an error status
+	commit := func() {
+		if response.Committed {
+			return
+		}
+		response.Header().Set(echo.HeaderContentType, exporter.ContentType())
+		response.Header().Set(echo.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="greenlibrary-%s.%s"`, time.Now().Format(time.DateOnly), exporter.Extension()))
+		response.WriteHeader(http.StatusOK)
+	}
+
+	err = dbContext.BookDb.ExportUserLibrary(claims["userKey"].(string), func(entry *models.ShelfEntry) error {
+		commit()
+		return exporter.Write(entry)
+	})
+	if err == nil {
+		commit()
+		err = exporter.Close()
+	}
+	if err != nil {
+		fmt.Println(err.Error())
+		if !response.Committed {
+			return echo.NewHTTPError(http.StatusInternalServerError, "No es posible exportar la biblioteca")
+		}
+		//the status was already sent, closing the connection is the only way to tell the client the file is incomplete
+		panic(http.ErrAbortHandler)
+	}
+	return nil
+}
+
 func HandlerLogin(c echo.Context) error {
 	dbContext := c.Get("dbContext").(*DatabaseContext)
 	userData := new(models.User)
@@ -296,42 +829,254 @@ func HandlerGetLibrary(c echo.Context) error {
 
 }
 
+// recibe la imagen "bookCover" y la asigna como portada del libro "bookID"
 func HandlerUploadImage(c echo.Context) error {
-	file, err := c.FormFile("bookCover")
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	bookID := c.FormValue("bookID")
+	if bookID == "" {
+		return echo.ErrBadRequest
+	}
+
+	data, err := readUploadedImage(c, "bookCover")
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	rendered, err := services.RenderCover(data, services.LoadRenditionConfig())
 	if err != nil {
 		fmt.Println(err.Error())
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, "La imagen no es válida")
+	}
+	covers, err := services.StoreRenditions(ctx, dbContext.Store, services.CoverPrefix, rendered.Renditions)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrInternalServerError
 	}
 
-	validExt := false
-	//valid types are only any variation of JPEG
-	for _, v := range [...]string{".jpeg", ".jpg"} {
-		if strings.ToLower(filepath.Ext(file.Filename)) == v {
-			validExt = true
-			break
+	cover := &models.StoredCover{Covers: covers, CoverPalette: rendered.Palette}
+	err = dbContext.BookDb.ReplaceCover(bookID, cover)
+	if err != nil {
+		fmt.Println(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return echo.ErrNotFound
 		}
+		return echo.ErrInternalServerError
 	}
-	if !validExt {
-		return echo.ErrBadRequest
+
+	return c.JSON(http.StatusOK, cover)
+}
+
+// restaura la portada que tenía el libro antes de la última subida
+func HandlerRollbackCover(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	cover, err := dbContext.BookDb.RollbackCover(c.Param("bookID"))
+	if err != nil {
+		fmt.Println(err.Error())
+		if errors.Is(err, pgx.ErrNoRows) {
+			return echo.NewHTTPError(http.StatusNotFound, "El libro no tiene una portada anterior")
+		}
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusOK, cover)
+}
+
+// recibe la imagen "avatar" del usuario autenticado. A diferencia de las portadas no se guarda la versión anterior:
+// el avatar solo lo cambia su dueño, que puede volver a subir la imagen previa, y las imágenes se guardan por su
+// contenido, así que subirla otra vez reutiliza los archivos que el GC todavía no borró
+func HandlerUploadAvatar(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	data, err := readUploadedImage(c, "avatar")
+	if err != nil {
+		return err
+	}
+
+	ctx := c.Request().Context()
+	config := services.LoadRenditionConfig()
+	config.Sizes = services.AvatarSizes
+	rendered, err := services.RenderCover(data, config)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.NewHTTPError(http.StatusBadRequest, "La imagen no es válida")
+	}
+	avatar, err := services.StoreRenditions(ctx, dbContext.Store, services.AvatarPrefix, rendered.Renditions)
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrInternalServerError
+	}
+
+	user := c.Get("user").(*jwt.Token)
+	claims := user.Claims.(jwt.MapClaims)
+	if err := dbContext.UserDB.UpdateAvatar(claims["userKey"].(string), avatar); err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrInternalServerError
+	}
+
+	return c.JSON(http.StatusOK, avatar)
+}
+
+// lee y valida la imagen del formulario, el tipo se obtiene del contenido y no de la extensión
+func readUploadedImage(c echo.Context, field string) ([]byte, error) {
+	file, err := c.FormFile(field)
+	if err != nil {
+		fmt.Println(err.Error())
+		return nil, echo.ErrBadRequest
 	}
 
 	src, err := file.Open()
 	if err != nil {
 		fmt.Println(err.Error())
-		return echo.ErrBadRequest
+		return nil, echo.ErrBadRequest
 	}
 	defer src.Close()
 
-	buff := bytes.NewBuffer(nil)
-	_, err = io.Copy(buff, src)
+	data, err := services.ReadUpload(src)
 	if err != nil {
+		if errors.Is(err, services.ErrImageTooLarge) {
+			return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "La imagen excede el tamaño permitido")
+		}
+		fmt.Println(err.Error())
+		return nil, echo.ErrBadRequest
+	}
+
+	if err := services.ValidateUpload(data); err != nil {
+		fmt.Println(err.Error())
+		if errors.Is(err, services.ErrTooManyPixels) {
+			return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge, "La imagen excede las dimensiones permitidas")
+		}
+		return nil, echo.NewHTTPError(http.StatusUnsupportedMediaType, "Solo se aceptan imágenes JPEG, PNG o WebP")
+	}
+
+	return data, nil
+}
+
+// tiene los params status, kind y ammount
+func HandlerGetJobs(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	ammount := 50
+	if c.QueryParam("ammount") != "" {
+		results, err := services.StringsToInts(c.QueryParam("ammount"))
+		if err != nil {
+			fmt.Println(err.Error())
+			return echo.ErrBadRequest
+		}
+		ammount = results[0]
+	}
+
+	jobs, err := dbContext.JobDB.ListJobs(c.QueryParam("status"), c.QueryParam("kind"), ammount)
+	if err != nil {
+		fmt.Println(err.Error())
 		return echo.ErrInternalServerError
 	}
-	resizedImage, err := services.ResizeImage(buff.Bytes())
+	return c.JSON(http.StatusOK, jobs)
+}
+
+func HandlerGetJob(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	job, err := dbContext.JobDB.GetJob(c.Param("jobID"))
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrNotFound
+	}
+	return c.JSON(http.StatusOK, job)
+}
+
+func HandlerRetryJob(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	job, err := dbContext.JobDB.RetryJob(c.Param("jobID"))
 	if err != nil {
+		fmt.Println(err.Error())
+		if errors.Is(err, db.ErrJobNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "El trabajo no existe o no se puede reintentar")
+		}
 		return echo.ErrInternalServerError
 	}
-	fmt.Println(resizedImage)
+	return c.JSON(http.StatusOK, job)
+}
 
-	return c.JSON(200, "hola")
+// vuelve a descargar las portadas que fallaron, con el param bookID solo la de ese libro
+func HandlerRefetchCovers(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	queued, err := dbContext.BookDb.RefetchCovers(c.QueryParam("bookID"))
+	if err != nil {
+		fmt.Println(err.Error())
+		return echo.ErrInternalServerError
+	}
+	return c.JSON(http.StatusAccepted, map[string]int{"queued": queued})
+}
+
+// sirve las imágenes del almacenamiento, con el param w se regresa la imagen con ese ancho
+func HandlerServeImage(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	key := c.Param("*")
+	if !services.ValidKey(key) {
+		return echo.ErrNotFound
+	}
+
+	var (
+		data []byte
+		err  error
+	)
+	ctx := c.Request().Context()
+	width := c.QueryParam("w")
+	if width != "" {
+		parsed, convErr := strconv.Atoi(width)
+		if convErr != nil {
+			return echo.ErrBadRequest
+		}
+		data, err = dbContext.ResizeCache.Resized(ctx, dbContext.Store, key, parsed)
+	} else {
+		data, err = dbContext.Store.Get(ctx, key)
+	}
+	if err != nil {
+		if errors.Is(err, services.ErrBlobNotFound) {
+			return echo.ErrNotFound
+		}
+		if errors.Is(err, services.ErrInvalidWidth) {
+			return echo.NewHTTPError(http.StatusBadRequest, "El ancho solicitado no está permitido")
+		}
+		fmt.Println(err.Error())
+		return echo.ErrInternalServerError
+	}
+
+	//los nombres con el hash del contenido nunca cambian, por lo que se pueden guardar para siempre
+	header := c.Response().Header()
+	if hash := services.ContentHash(key); hash != "" {
+		header.Set("ETag", fmt.Sprintf(`"%s%s"`, hash, widthSuffix(width)))
+		header.Set("Cache-Control", "public, max-age=31536000, immutable")
+	} else {
+		sum := sha256.Sum256(data)
+		header.Set("ETag", fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:])))
+		header.Set("Cache-Control", "public, max-age=3600")
+	}
+	header.Set(echo.HeaderContentType, services.ContentTypeByKey(key))
+
+	//ServeContent responde a los headers Range, If-Range e If-None-Match usando el ETag
+	http.ServeContent(c.Response(), c.Request(), path.Base(key), time.Time{}, bytes.NewReader(data))
+	return nil
+}
+
+func widthSuffix(width string) string {
+	if width == "" {
+		return ""
+	}
+	return "-w" + width
+}
+
+// estado de los proveedores del catálogo y de sus circuit breakers
+func HandlerGetCatalogStatus(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	return c.JSON(http.StatusOK, map[string]any{
+		"offline":   dbContext.Catalog.Offline(),
+		"providers": dbContext.Catalog.Status(),
+	})
+}
+
+// contadores de la caché de los catálogos externos
+func HandlerGetCacheStats(c echo.Context) error {
+	dbContext := c.Get("dbContext").(*DatabaseContext)
+	if dbContext.CatalogCache == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "La caché está desactivada")
+	}
+	return c.JSON(http.StatusOK, dbContext.CatalogCache.Stats())
 }
@@ -1,11 +1,16 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"strconv"
 
 	"github.com/TheSgtPepper23/GreenLibrary/db"
+	"github.com/TheSgtPepper23/GreenLibrary/jobs"
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
 	"github.com/joho/godotenv"
 	echojwt "github.com/labstack/echo-jwt"
 	"github.com/labstack/echo/v4"
@@ -13,9 +18,16 @@ import (
 )
 
 type DatabaseContext struct {
-	CollDB *db.CollectionSQLContext
-	BookDb *db.BookSQLContext
-	UserDB *db.UserSQLContext
+	CollDB       *db.CollectionSQLContext
+	BookDb       *db.BookSQLContext
+	UserDB       *db.UserSQLContext
+	ImportDB     *db.ImportSQLContext
+	JobDB        *db.JobSQLContext
+	Store        services.BlobStore
+	ResizeCache  *services.ResizeCache
+	Catalog      *services.Catalog
+	CatalogCache *services.ResponseCache
+	SuggestCache *services.SuggestCache
 }
 
 func main() {
@@ -32,18 +44,65 @@ func main() {
 		server.StdLogger.Fatal()
 	}
 	defer conn.Close()
+
+	//with arguments the binary runs a maintenance command instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:], conn); err != nil {
+			log.Fatal(err.Error())
+		}
+		return
+	}
+
+	store, err := services.NewBlobStoreFromEnv()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+
 	dbContext := &DatabaseContext{
-		CollDB: db.NewSQLCollectionContext(conn),
-		BookDb: db.NewSQLBookContext(conn),
-		UserDB: db.NewSQLUserContext(conn),
+		CollDB:       db.NewSQLCollectionContext(conn),
+		BookDb:       db.NewSQLBookContext(conn),
+		UserDB:       db.NewSQLUserContext(conn),
+		ImportDB:     db.NewSQLImportContext(conn),
+		JobDB:        db.NewSQLJobContext(conn),
+		Store:        store,
+		ResizeCache:  services.NewResizeCacheFromEnv(),
+		SuggestCache: services.NewSuggestCacheFromEnv(),
+	}
+
+	//CATALOG_CACHE=false disables the cache and CATALOG_CACHE_PERSIST=true keeps it in the database
+	if os.Getenv("CATALOG_CACHE") != "false" {
+		var store services.ResponseStore
+		if os.Getenv("CATALOG_CACHE_PERSIST") == "true" {
+			store = db.NewSQLCatalogCacheContext(conn)
+		}
+		dbContext.CatalogCache = services.NewResponseCache(services.LoadCacheConfig(), store)
+	}
+	dbContext.Catalog, err = services.NewCatalogFromEnv(db.NewLocalCatalog(dbContext.BookDb), dbContext.CatalogCache)
+	if err != nil {
+		log.Fatal(err.Error())
 	}
 
+	workers, err := strconv.Atoi(os.Getenv("JOB_WORKERS"))
+	if err != nil {
+		workers = 4
+	}
+	pool := jobs.NewPool(dbContext.JobDB, workers)
+	pool.Register(models.JobCoverDownload, jobs.CoverDownload(dbContext.BookDb, dbContext.Store))
+	pool.Register(models.JobImportLibrary, jobs.ImportLibrary(dbContext.ImportDB))
+	pool.Register(models.JobImportISBNs, jobs.ImportISBNs(dbContext.ImportDB, dbContext.Catalog))
+	pool.Register(models.JobImageGC, jobs.ImageGC(dbContext.BookDb, dbContext.Store))
+	pool.Register(models.JobVocabulary, jobs.RefreshVocabulary(dbContext.BookDb))
+	pool.Start(context.Background())
+	pool.Schedule(context.Background(), models.JobImageGC, jobs.GCInterval(), models.ImageGCPayload{})
+	pool.Schedule(context.Background(), models.JobVocabulary, jobs.VocabularyInterval(), struct{}{})
+
 	server.Use(middleware.Logger())
 	server.Use(middleware.Recover())
 	server.Use(middleware.CORSWithConfig(middleware.CORSConfig{
 		AllowOrigins:     []string{"http://localhost:5173", "https://andresdglez.com"}, // Allowed origins
 		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete},
 		AllowHeaders:     []string{echo.HeaderOrigin, echo.HeaderContentType, echo.HeaderAccept, echo.HeaderAuthorization},
+		ExposeHeaders:    []string{"X-Failed-Sources", "X-Sort-Seed"},
 		AllowCredentials: true, // Set to true if your API requires credentials (e.g., cookies)
 	}))
 	server.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
@@ -57,6 +116,12 @@ func main() {
 		return c.String(http.StatusOK, "OK")
 	})
 
+	//Covers of the local storage, IMG_URL must point to this route (e.g. https://host/images/)
+	server.GET("/images/*", HandlerServeImage)
+
+	//the forms with images are rejected before they are parsed when they are too large
+	uploadLimit := middleware.BodyLimit(services.UploadBodyLimit())
+
 	//Collection endpoints
 	collServices := server.Group("/collection", echojwt.JWT([]byte(secret)))
 	collServices.POST("", HandlerCreateCollection)
@@ -69,21 +134,43 @@ func main() {
 	bookServices.POST("", HandlerCreateNewBook)
 	bookServices.PUT("", HandlerUpdateBook)
 	bookServices.GET("/:collection", HandlerGetCollectonBooks)
+	bookServices.GET("/search", HandlerSearchCatalog)
+	bookServices.GET("/suggest", HandlerSuggestBooks)
+	bookServices.POST("/scan", HandlerScanBarcodes, uploadLimit)
 	bookServices.POST("/search", HandlerSearchBook)
+	bookServices.GET("/search/user", HandlerSearchUserBooks)
 	bookServices.POST("/search/user", HandlerSearchUserBook)
 	bookServices.PUT("/delete", HandlerRemoveFromCollection)
 	bookServices.PUT("/move", HandlerMoveBook)
+	bookServices.PUT("/reorder", HandlerReorderBooks)
+	bookServices.POST("/import/goodreads", HandlerImportGoodreads)
+	bookServices.POST("/import/storygraph", HandlerImportStoryGraph)
+	bookServices.POST("/import/isbns", HandlerImportISBNs)
+
+	//Endpoints of the authenticated user
+	meServices := server.Group("/me", echojwt.JWT([]byte(secret)))
+	meServices.GET("/export", HandlerExportLibrary)
+	meServices.GET("/jobs/:jobID", HandlerGetUserJob)
+	meServices.POST("/avatar", HandlerUploadAvatar, uploadLimit)
 
 	//Auth endpoints
 	authServices := server.Group("/auth")
 	authServices.POST("/login", HandlerLogin)
 	authServices.POST("/refresh", HandlerRefreshToken)
 
-	//Admin endpoints
-	adminServices := server.Group("/admin", echojwt.JWT([]byte(secret)))
+	//Admin endpoints, the jobs of every user and the shared catalog are only available to admins
+	adminServices := server.Group("/admin", echojwt.JWT([]byte(secret)), RequireAdmin)
 	adminServices.POST("/register", HandlerRegister)
 	adminServices.GET("/library", HandlerGetLibrary)
-	adminServices.POST("/image", HandlerUploadImage)
+	//the covers are shared by every user, so only admins replace them. Users change only their own avatar in /me
+	adminServices.POST("/image", HandlerUploadImage, uploadLimit)
+	adminServices.GET("/jobs", HandlerGetJobs)
+	adminServices.GET("/jobs/:jobID", HandlerGetJob)
+	adminServices.POST("/jobs/:jobID/retry", HandlerRetryJob)
+	adminServices.POST("/covers/refetch", HandlerRefetchCovers)
+	adminServices.POST("/covers/:bookID/rollback", HandlerRollbackCover)
+	adminServices.GET("/cache/stats", HandlerGetCacheStats)
+	adminServices.GET("/catalog/status", HandlerGetCatalogStatus)
 
 	server.Logger.Fatal(server.Start(":5555"))
 }
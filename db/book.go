@@ -4,8 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/TheSgtPepper23/GreenLibrary/models"
@@ -15,15 +15,24 @@ import (
 )
 
 type BookSQLContext struct {
-	conn *pgxpool.Pool
+	conn  *pgxpool.Pool
+	fuzzy services.FuzzyConfig
 }
 
 func NewSQLBookContext(pool *pgxpool.Pool) *BookSQLContext {
 	return &BookSQLContext{
-		conn: pool,
+		conn:  pool,
+		fuzzy: services.LoadFuzzyConfig(),
 	}
 }
 
+// the renditions are only stored once the cover is downloaded, until then the original URL is used
+const coversColumn = `COALESCE(b.cover_renditions, CASE WHEN COALESCE(b.cover_url, '') = '' THEN '{}'::jsonb
+	ELSE jsonb_build_object('original', jsonb_build_object('jpeg', b.cover_url)) END)`
+
+// the palette is empty until the cover is processed
+const paletteColumn = `COALESCE(b.cover_palette, '{}'::jsonb)`
+
 func validateBookIsStored(bookKey string, conn *pgxpool.Pool) (string, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
@@ -86,21 +95,39 @@ func markBookAsRead(book *models.Book, userID string, tx pgx.Tx, ctx context.Con
 	return nil
 }
 
-func updateBookImageURL(newURL, bookKey string, conn *pgxpool.Pool) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
-	defer cancel()
-
-	_, err := conn.Exec(ctx, "UPDATE public.book SET cover_url = $1 WHERE key = $2", newURL, bookKey)
+// Registers a new book in the catalog and queues the download of its cover. The job is part of the
+// same transaction, so the cover is only downloaded if the book is stored
+func insertBook(book *models.Book, tx pgx.Tx, ctx context.Context) error {
+	book.ID = services.GenerateUUID()
+	book.CoverStatus = models.CoverPending
+	_, err := tx.Exec(ctx, `INSERT INTO public.book
+		(  id, title,  author,  "key",  author_key,
+		release_year,  cover_url, avg_rating,  page_count, isbn,
+		cover_source_url, cover_status)
+		VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $7, $11 )`,
+		book.ID, book.Title, book.Author, book.Key, book.AuthorKey,
+		book.ReleaseYear, book.Covers.Source(), book.AVGRating, book.PageCount, nullString(book.ISBN),
+		book.CoverStatus)
 	if err != nil {
-		fmt.Println(err.Error())
+		return err
 	}
 
-	return
+	_, err = enqueueJob(tx, ctx, models.JobCoverDownload, models.CoverJobPayload{
+		BookKey: book.Key,
+		URL:     book.Covers.Source(),
+		Title:   book.Title,
+		Author:  book.Author,
+	}, "")
+	return err
 }
 
 // Se utiliza para agregar un nuevo libro y asignarlo a una colección
 // sa valida que el libro no esté guardado anteriormente para evitar duplicados en la base de datos
 func (c *BookSQLContext) CreateNewBook(book *models.Book, userID string) error {
+	if err := c.checkManualCollection(book.CollecionID); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
 	defer cancel()
 	//se revisa si el libro ya existe en la base de datos
@@ -129,27 +156,11 @@ func (c *BookSQLContext) CreateNewBook(book *models.Book, userID string) error {
 			return errors.New("book already read")
 		}
 	} else {
-		done := make(chan (bool))
-		go services.ProcessImage(book.CoverURL, book.Key, done, func(newURL string) {
-			updateBookImageURL(newURL, book.Key, c.conn)
-		})
-
-		book.ID = services.GenerateUUID()
-		_, err = tx.Exec(ctx, `INSERT INTO public.book
-			(  id, title,  author,  "key",  author_key,
-			release_year,  cover_url, avg_rating,  page_count)
-			VALUES ( $1, $2, $3, $4, $5, $6, $7, $8, $9 )`,
-			book.ID, book.Title, book.Author, book.Key, book.AuthorKey,
-			book.ReleaseYear, book.CoverURL, book.AVGRating, book.PageCount)
-
+		err = insertBook(book, tx, ctx)
 		if err != nil {
-			//the goroutine will proceed and fail, creating an orphan image...
-			done <- false
+			tx.Rollback(ctx)
 			return err
 		}
-		//signals the goroutine to proceed with the update
-		done <- true
-		close(done)
 	}
 
 	//si la fecha de terminado de un libro no es 0 (año 0 o literalmente null) significa que se está marcando como leído
@@ -180,51 +191,132 @@ func (c *BookSQLContext) CreateNewBook(book *models.Book, userID string) error {
 	return nil
 }
 
+// Fails with ErrSmartCollection if the collection is smart
+func (c *BookSQLContext) checkManualCollection(collectionID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return checkManualCollection(c.conn, ctx, collectionID)
+}
+
+// Points the book to its local renditions once they have been stored
+func (c *BookSQLContext) UpdateCovers(bookKey string, cover *models.StoredCover) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := c.conn.Exec(ctx, `UPDATE public.book SET cover_url = $1, cover_renditions = $2, cover_palette = $3,
+		cover_status = $4 WHERE key = $5`, cover.Covers.Source(), cover.Covers, cover.CoverPalette, models.CoverStored, bookKey)
+	return err
+}
+
+func (c *BookSQLContext) SetCoverStatus(bookKey, status string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	_, err := c.conn.Exec(ctx, "UPDATE public.book SET cover_status = $1 WHERE key = $2", status, bookKey)
+	return err
+}
+
+// Queues again the download of the failed covers, or only the one of the given book. The original URL
+// of the cover is used since cover_url may already point to this server
+func (c *BookSQLContext) RefetchCovers(bookID string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
+
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	rows, err := tx.Query(ctx, `UPDATE public.book SET cover_status = $1
+		WHERE ($2 = '' AND cover_status = $3) OR id::text = $2
+		RETURNING "key", COALESCE(cover_source_url, cover_url, ''), title, author`,
+		models.CoverPending, bookID, models.CoverFailed)
+	if err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+
+	payloads := make([]models.CoverJobPayload, 0)
+	for rows.Next() {
+		var payload models.CoverJobPayload
+		if err := rows.Scan(&payload.BookKey, &payload.URL, &payload.Title, &payload.Author); err != nil {
+			rows.Close()
+			tx.Rollback(ctx)
+			return 0, err
+		}
+		payloads = append(payloads, payload)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+
+	for _, payload := range payloads {
+		if _, err := enqueueJob(tx, ctx, models.JobCoverDownload, payload, ""); err != nil {
+			tx.Rollback(ctx)
+			return 0, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+
+	return len(payloads), nil
+}
+
 func (c *BookSQLContext) UpdateBook(book *models.Book) error {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
+	//the covers are managed by the image pipeline, so they are not updated here
 	_, err := c.conn.Exec(ctx, `UPDATE public.book SET
 		title = $1,
 		author = $2,
 		"key" = $3,
 		author_key = $4,
 		release_year = $5,
-		cover_url = $6,
-		avg_rating = $7,
-		page_count = $8,
-		WHERE id = $9`,
+		avg_rating = $6,
+		page_count = $7
+		WHERE id = $8`,
 		book.Title, book.Author, book.Key, book.AuthorKey, book.ReleaseYear,
-		book.CoverURL, book.MyRating, book.AVGRating, book.PageCount, book.ID)
+		book.AVGRating, book.PageCount, book.ID)
 	return err
 }
 
-func (c *BookSQLContext) GetBooksOfCollection(collectionID string, ammount, page int, order models.OrderOption) (*[]models.Book, error) {
+func (c *BookSQLContext) GetBooksOfCollection(collectionID string, ammount, page int, sort models.BookSort) (*[]models.Book, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 	//make so its not a nil value
 	books := make([]models.Book, 0)
 
 	query := `SELECT  b.id, b.title, b.author, b."key", b.author_key, b.release_year,
-		chb.date_added, chb.start_reading, chb.finish_reading, b.cover_url,
-		chb.rating, chb."comment", b.avg_rating, b.page_count, chb.collection_id
-		FROM public.book b LEFT JOIN public.collection_has_book chb ON b.id = chb.book_id
-		WHERE chb.collection_id = $1`
-
-	var orderOpt string
-	switch order {
-	case models.DateAsc:
-		orderOpt = `ORDER BY chb.date_added ASC`
-	case models.DateDesc:
-		orderOpt = `ORDER BY chb.date_added DESC`
-	case models.NameAsc:
-		orderOpt = `ORDER BY b.title ASC`
-	case models.NameDesc:
-		orderOpt = `ORDER BY b.title DESC`
-	default:
-		orderOpt = `ORDER BY chb.date_added DESC`
-	}
-
-	rows, err := c.conn.Query(ctx, fmt.Sprintf("%s %s LIMIT $2 OFFSET $3", query, orderOpt), collectionID, ammount, (ammount * (page - 1)))
+		chb.date_added, chb.start_reading, chb.finish_reading, ` + coversColumn + `, ` + paletteColumn + `,
+		chb.rating, chb."comment", b.avg_rating, b.page_count, chb.collection_id, chb.tags, chb.moods
+		FROM public.book b LEFT JOIN public.collection_has_book chb ON b.id = chb.book_id`
+	condition := `chb.collection_id = $1`
+	args := []any{collectionID, ammount, (ammount * (page - 1))}
+
+	//the books of a smart collection are the entries of the owner that match the rules, they keep the
+	//ID of the collection where they are stored
+	ownerID, rules, err := collectionRules(c.conn, ctx, collectionID)
+	if err != nil && err != pgx.ErrNoRows {
+		return nil, err
+	}
+	if rules != nil {
+		args[0] = ownerID
+		cte, err := smartBooksCTE(rules, &args)
+		if err != nil {
+			return nil, err
+		}
+		query = cte + query
+		condition = `chb.id IN (SELECT id FROM smart)`
+	}
+
+	orderOpt := orderBooks(sort, rules != nil, &args)
+	rows, err := c.conn.Query(ctx, fmt.Sprintf("%s WHERE %s %s LIMIT $2 OFFSET $3", query, condition, orderOpt), args...)
 
 	if err != nil {
 		return nil, err
@@ -240,12 +332,67 @@ func (c *BookSQLContext) GetBooksOfCollection(collectionID string, ammount, page
 	return &books, nil
 }
 
+// Columns of the sort keys, the text is compared without case so the lowercase titles don't go last
+var sortColumns = map[string]string{
+	models.SortTitle:       `lower(b.title)`,
+	models.SortAuthor:      `lower(b.author)`,
+	models.SortDateAdded:   `chb.date_added`,
+	models.SortRating:      `chb.rating`,
+	models.SortAVGRating:   `b.avg_rating`,
+	models.SortStarted:     `chb.start_reading`,
+	models.SortFinished:    `chb.finish_reading`,
+	models.SortPageCount:   `b.page_count`,
+	models.SortReleaseYear: `b.release_year`,
+}
+
+// Builds the ORDER BY of the books of a collection. The books without a value go last in both directions,
+// and the title and ID break the ties so the pages are stable
+func orderBooks(sort models.BookSort, smart bool, args *[]any) string {
+	terms := make([]string, 0, len(sort.Keys)+2)
+	for _, key := range sort.Keys {
+		switch key.Field {
+		case models.SortManual:
+			//the books of a smart collection come from other collections, so their positions don't apply
+			if smart {
+				terms = append(terms, `chb.date_added DESC`)
+			} else {
+				terms = append(terms, manualOrder)
+			}
+		case models.SortRandom:
+			*args = append(*args, strconv.FormatInt(sort.Seed, 10))
+			terms = append(terms, fmt.Sprintf(`md5(b.id::text || $%d)`, len(*args)))
+		default:
+			column, ok := sortColumns[key.Field]
+			if !ok {
+				continue
+			}
+			direction := "ASC"
+			if key.Desc {
+				direction = "DESC"
+			}
+			terms = append(terms, column+" "+direction+" NULLS LAST")
+		}
+	}
+
+	if !sort.Has(models.SortTitle) {
+		terms = append(terms, sortColumns[models.SortTitle]+" ASC")
+	}
+	terms = append(terms, `b.id ASC`)
+	return "ORDER BY " + strings.Join(terms, ", ")
+}
+
+// The books can't be moved to a smart collection, they are added to it by its rules
 func (c *BookSQLContext) MoveBook(book *models.Book, userID string) error {
+	if err := c.checkManualCollection(book.CollecionID); err != nil {
+		return err
+	}
+
 	if book.FinishReading.IsZero() {
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 		defer cancel()
 		_, err := c.conn.Exec(ctx,
-			`UPDATE public.collection_has_book SET collection_id = $1, "comment" = $2, rating = $3 WHERE book_id = $4`,
+			`UPDATE public.collection_has_book SET collection_id = $1, "comment" = $2, rating = $3,
+			position = CASE WHEN collection_id = $1 THEN position END WHERE book_id = $4`,
 			book.CollecionID, book.Comment, book.MyRating, book.ID)
 		return err
 	} else {
@@ -272,6 +419,10 @@ func (c *BookSQLContext) MoveBook(book *models.Book, userID string) error {
 }
 
 func (c *BookSQLContext) RemoveBookFromCollection(book *models.Book) error {
+	if err := c.checkManualCollection(book.CollecionID); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
 	_, err := c.conn.Exec(ctx, `DELETE FROM public.collection_has_book WHERE book_id = $1 AND collection_id = $2`, book.ID, book.CollecionID)
@@ -285,7 +436,7 @@ func (c *BookSQLContext) GetAllStoredBooks() (*[]models.Book, error) {
 	books := make([]models.Book, 0)
 
 	query := `SELECT  b.id, b.title, b.author, b."key", b.author_key, b.release_year,
-		b.cover_url, b.avg_rating, b.page_count FROM public.book b`
+		` + coversColumn + `, ` + paletteColumn + `, b.avg_rating, b.page_count, b.cover_status FROM public.book b`
 
 	rows, err := c.conn.Query(ctx, query)
 
@@ -298,7 +449,7 @@ func (c *BookSQLContext) GetAllStoredBooks() (*[]models.Book, error) {
 	for rows.Next() {
 		var temp models.Book
 		err := rows.Scan(&temp.ID, &temp.Title, &temp.Author, &temp.Key, &temp.AuthorKey,
-			&temp.ReleaseYear, &temp.CoverURL, &temp.AVGRating, &temp.PageCount)
+			&temp.ReleaseYear, &temp.Covers, &temp.CoverPalette, &temp.AVGRating, &temp.PageCount, &temp.CoverStatus)
 		if err != nil {
 			return nil, err
 		}
@@ -308,75 +459,158 @@ func (c *BookSQLContext) GetAllStoredBooks() (*[]models.Book, error) {
 	return &books, nil
 }
 
-func (c *BookSQLContext) SearchBookLocally(searchTerm string, targetList *[]models.Book, wg *sync.WaitGroup, mu *sync.Mutex, errChan chan (error)) {
-	defer wg.Done()
-	start := time.Now()
-	searchTerm = strings.ReplaceAll(searchTerm, " ", " & ")
-
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
-	defer cancel()
+// The stored books don't have language or subject, so a search filtered by them finds nothing locally
+func (c *BookSQLContext) SearchBookLocally(ctx context.Context, search models.SearchQuery) (*services.CatalogPage, error) {
+	page := &services.CatalogPage{Books: make([]models.Book, 0)}
+	if search.Language != "" || search.Subject != "" {
+		return page, nil
+	}
 
-	query := `SELECT b.id, b.title, b.author, b."key", b.author_key, b.release_year,
-		b.cover_url, b.avg_rating, b.page_count FROM public.book as b
-	 	WHERE to_tsvector('english', title || ' ' || author) @@ to_tsquery('english', $1)`
+	conditions := make([]string, 0)
+	args := make([]any, 0)
+	addCondition := func(condition string, arg any) {
+		args = append(args, arg)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+	order := "b.title, b.id"
+	if text := services.WebSearchQuery(search.Query); text != "" {
+		addCondition(`b.search_vector @@ public.library_query($%d)`, text)
+		order = fmt.Sprintf("ts_rank(b.search_vector, public.library_query($%d)) DESC, b.title, b.id", len(args))
+	} else if search.Query != "" {
+		return page, nil
+	}
+	if search.Title != "" {
+		addCondition(`b.title ILIKE '%%' || $%d || '%%'`, likePattern(search.Title))
+	}
+	if search.Author != "" {
+		addCondition(`b.author ILIKE '%%' || $%d || '%%'`, likePattern(search.Author))
+	}
+	if search.ISBN != "" {
+		addCondition(`b.isbn = $%d`, search.ISBN)
+	}
+	if search.YearFrom > 0 {
+		addCondition(`b.release_year >= $%d`, search.YearFrom)
+	}
+	if search.YearTo > 0 {
+		addCondition(`b.release_year <= $%d`, search.YearTo)
+	}
+	if len(conditions) == 0 {
+		return page, nil
+	}
 
-	rows, err := c.conn.Query(ctx, query, searchTerm)
+	args = append(args, search.Limit, search.Offset())
+	query := fmt.Sprintf(`SELECT b.id, b.title, b.author, b."key", b.author_key, b.release_year,
+		`+coversColumn+`, `+paletteColumn+`, b.avg_rating, b.page_count, b.isbn, count(*) OVER ()
+		FROM public.book as b WHERE %s ORDER BY %s LIMIT $%d OFFSET $%d`,
+		strings.Join(conditions, " AND "), order, len(args)-1, len(args))
 
+	rows, err := c.conn.Query(ctx, query, args...)
 	if err != nil {
-		errChan <- err
-		return
+		return nil, err
 	}
 
 	defer rows.Close()
 
 	for rows.Next() {
-		var temp models.Book
+		var (
+			temp models.Book
+			isbn *string
+		)
 		err := rows.Scan(&temp.ID, &temp.Title, &temp.Author, &temp.Key, &temp.AuthorKey,
-			&temp.ReleaseYear, &temp.CoverURL, &temp.AVGRating, &temp.PageCount)
+			&temp.ReleaseYear, &temp.Covers, &temp.CoverPalette, &temp.AVGRating, &temp.PageCount, &isbn, &page.Total)
 
 		if err != nil {
 			fmt.Println(err.Error())
 			continue
 		}
+		if isbn != nil {
+			temp.ISBN = *isbn
+		}
 		temp.LocallyStored = true
-		mu.Lock()
-		*targetList = append(*targetList, temp)
-		mu.Unlock()
+		page.Books = append(page.Books, temp)
 	}
-	fmt.Println(time.Since(start).Milliseconds())
-	return
+	return page, rows.Err()
 }
 
-func (c *BookSQLContext) SearchUserBooks(searchTerm, collectionId, userKey string) (*[]models.Book, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+// Returns which of the keys belong to books in any collection of the user
+func (c *BookSQLContext) ShelvedKeys(userID string, keys []string) (map[string]bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
 	defer cancel()
 
-	searchTerm = strings.ReplaceAll(searchTerm, " ", " & ")
-
-	query := `SELECT  b.id, b.title, b.author, b."key", b.author_key, b.release_year, chb.date_added,
-			chb.start_reading, chb.finish_reading, b.cover_url, chb.rating, chb."comment", b.avg_rating,
-			b.page_count, chb.collection_id FROM public.book as b LEFT JOIN public.collection_has_book as chb ON b.id = chb.book_id `
-
-	var firstArg string
-	if collectionId != "" {
-		query = fmt.Sprint(query, `WHERE chb.collection_id = $1
-			AND to_tsvector('english', b.title || ' ' || b.author) @@ to_tsquery('english', $2);`)
-		firstArg = collectionId
-	} else {
-		query = fmt.Sprint(query, `LEFT JOIN collection c on c.id = chb.collection_id
-			WHERE c.owner_id = $1 AND to_tsvector('english', b.title || ' ' || b.author) @@ to_tsquery('english', $2);`)
-		firstArg = userKey
+	shelved := make(map[string]bool)
+	if len(keys) == 0 {
+		return shelved, nil
 	}
 
-	rows, err := c.conn.Query(ctx, query, firstArg, searchTerm)
+	rows, err := c.conn.Query(ctx, `SELECT DISTINCT b."key" FROM public.book b
+		JOIN public.collection_has_book chb ON chb.book_id = b.id
+		JOIN public.collection c ON c.id = chb.collection_id
+		WHERE c.owner_id = $1 AND b."key" = ANY($2)`, userID, keys)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		shelved[key] = true
+	}
+	return shelved, rows.Err()
+}
+
+// The books already stored as a provider of the catalog
+type LocalCatalog struct {
+	books *BookSQLContext
+}
+
+func NewLocalCatalog(books *BookSQLContext) *LocalCatalog {
+	return &LocalCatalog{books: books}
+}
+
+func (l *LocalCatalog) Name() string {
+	return services.ProviderLocal
+}
+
+func (l *LocalCatalog) Search(ctx context.Context, query models.SearchQuery) (*services.CatalogPage, error) {
+	return l.books.SearchBookLocally(ctx, query)
+}
+
+// Looks for the books of a collection, or of every collection of the user when there's no collection.
+// When the full text search finds few books the similar titles and authors are added after them
+func (c *BookSQLContext) SearchUserBooks(searchTerm, collectionId, userKey string) (*[]models.Book, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
 	foundBooks := make([]models.Book, 0)
-	err = scanBooks(rows, &foundBooks)
-	if err != nil {
-		return nil, err
+	scope, scopeArg := userSearchScope(collectionId, userKey)
+
+	if text := services.WebSearchQuery(searchTerm); text != "" {
+		query := `SELECT  b.id, b.title, b.author, b."key", b.author_key, b.release_year, chb.date_added,
+			chb.start_reading, chb.finish_reading, ` + coversColumn + `, ` + paletteColumn + `, chb.rating, chb."comment", b.avg_rating,
+			b.page_count, chb.collection_id, chb.tags, chb.moods FROM public.book as b ` + scope + `
+			AND b.search_vector @@ public.library_query($2)
+			ORDER BY ts_rank(b.search_vector, public.library_query($2)) DESC, b.title, b.id`
+
+		rows, err := c.conn.Query(ctx, query, scopeArg, text)
+		if err != nil {
+			return nil, err
+		}
+		err = scanBooks(rows, &foundBooks)
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(foundBooks) < c.fuzzy.MinResults && strings.TrimSpace(searchTerm) != "" {
+		similar, err := c.fuzzyUserBooks(ctx, searchTerm, scope, scopeArg)
+		if err != nil {
+			return nil, err
+		}
+		foundBooks = appendMissingBooks(foundBooks, similar)
 	}
 
 	return &foundBooks, nil
@@ -395,8 +629,8 @@ func scanBooks(rows pgx.Rows, target *[]models.Book) error {
 		)
 
 		err := rows.Scan(&temp.ID, &temp.Title, &temp.Author, &temp.Key, &temp.AuthorKey, &temp.ReleaseYear,
-			&dateAdded, &startReading, &finishReading, &temp.CoverURL, &myRating, &comment, &avgRating,
-			&temp.PageCount, &temp.CollecionID)
+			&dateAdded, &startReading, &finishReading, &temp.Covers, &temp.CoverPalette, &myRating, &comment, &avgRating,
+			&temp.PageCount, &temp.CollecionID, &temp.Tags, &temp.Moods)
 
 		if err != nil {
 			return err
@@ -426,3 +660,190 @@ func scanBooks(rows pgx.Rows, target *[]models.Book) error {
 
 	return nil
 }
+
+// Sends every shelf entry of the user to the callback without loading the whole library in memory.
+// The entries of the same book are sent one after the other
+func (c *BookSQLContext) ExportUserLibrary(userID string, callback func(*models.ShelfEntry) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := c.conn.Query(ctx, `SELECT b.id, b.title, b.author, b."key", b.author_key, b.release_year,
+		chb.date_added, chb.start_reading, chb.finish_reading, `+coversColumn+`, `+paletteColumn+`, chb.rating, chb."comment",
+		b.avg_rating, b.page_count, chb.collection_id, chb.tags, chb.moods, b.isbn, c.name, c.read_col
+		FROM public.collection_has_book chb
+		JOIN public.collection c ON c.id = chb.collection_id
+		JOIN public.book b ON b.id = chb.book_id
+		WHERE c.owner_id = $1
+		ORDER BY b.title, b.id, c.read_col DESC, chb.date_added`, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			dateAdded     *time.Time
+			startReading  *time.Time
+			finishReading *time.Time
+			myRating      *float32
+			avgRating     *float32
+			comment       *string
+			isbn          *string
+			entry         models.ShelfEntry
+		)
+
+		err := rows.Scan(&entry.ID, &entry.Title, &entry.Author, &entry.Key, &entry.AuthorKey, &entry.ReleaseYear,
+			&dateAdded, &startReading, &finishReading, &entry.Covers, &entry.CoverPalette, &myRating, &comment, &avgRating,
+			&entry.PageCount, &entry.CollecionID, &entry.Tags, &entry.Moods, &isbn, &entry.CollectionName, &entry.ReadCol)
+		if err != nil {
+			return err
+		}
+
+		if dateAdded != nil {
+			entry.DateAdded = *dateAdded
+		}
+		if startReading != nil {
+			entry.StartReading = *startReading
+		}
+		if finishReading != nil {
+			entry.FinishReading = *finishReading
+		}
+		if myRating != nil {
+			entry.MyRating = *myRating
+		}
+		if avgRating != nil {
+			entry.AVGRating = *avgRating
+		}
+		if comment != nil {
+			entry.Comment = *comment
+		}
+		if isbn != nil {
+			entry.ISBN = *isbn
+		}
+
+		if err := callback(&entry); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// Replaces the base of the URLs of the stored covers and avatars, used when the images move to another storage.
+// The bases can include the start of the keys to move only some of the images
+func (c *BookSQLContext) RewriteCoverURLs(oldBase, newBase string) (int64, error) {
+	if oldBase == "" {
+		return 0, errors.New("the base URL of the source storage is empty")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	//the previous covers and the avatars are in the same storage, so they move too or the rollbacks and the
+	//GC would still look for them in the old one
+	tag, err := tx.Exec(ctx, `UPDATE public.book SET
+		cover_url = replace(cover_url, $1, $2),
+		cover_renditions = replace(cover_renditions::text, $1, $2)::jsonb,
+		previous_cover_url = replace(previous_cover_url, $1, $2),
+		previous_cover_renditions = replace(previous_cover_renditions::text, $1, $2)::jsonb
+		WHERE position($1 in cover_url) > 0 OR position($1 in cover_renditions::text) > 0
+		OR position($1 in previous_cover_url) > 0 OR position($1 in previous_cover_renditions::text) > 0`, oldBase, newBase)
+	if err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+	updated := tag.RowsAffected()
+
+	tag, err = tx.Exec(ctx, `UPDATE public."user" SET avatar = replace(avatar::text, $1, $2)::jsonb
+		WHERE position($1 in avatar::text) > 0`, oldBase, newBase)
+	if err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+	updated += tag.RowsAffected()
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return 0, err
+	}
+	return updated, nil
+}
+
+// Sets a cover uploaded by an admin. The current cover is kept so it can be restored with RollbackCover
+func (c *BookSQLContext) ReplaceCover(bookID string, cover *models.StoredCover) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tag, err := c.conn.Exec(ctx, `UPDATE public.book SET
+		previous_cover_url = cover_url,
+		previous_cover_renditions = cover_renditions,
+		previous_cover_palette = cover_palette,
+		cover_url = $1,
+		cover_renditions = $2,
+		cover_palette = $3,
+		cover_status = $4
+		WHERE id::text = $5`, cover.Covers.Source(), cover.Covers, cover.CoverPalette, models.CoverStored, bookID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// Swaps the current cover with the previous one, so a rollback can also be undone
+func (c *BookSQLContext) RollbackCover(bookID string) (*models.StoredCover, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	var cover models.StoredCover
+	err := c.conn.QueryRow(ctx, `UPDATE public.book b SET
+		cover_url = previous_cover_url,
+		cover_renditions = previous_cover_renditions,
+		cover_palette = previous_cover_palette,
+		previous_cover_url = cover_url,
+		previous_cover_renditions = cover_renditions,
+		previous_cover_palette = cover_palette,
+		cover_status = $1
+		WHERE id::text = $2 AND previous_cover_url IS NOT NULL
+		RETURNING `+coversColumn+`, `+paletteColumn, models.CoverStored, bookID).Scan(&cover.Covers, &cover.CoverPalette)
+	return &cover, err
+}
+
+// Sends to the callback every image URL used by the books and the users, including the previous covers
+// kept for a rollback. The URLs of other services, like the ones of open library, are also sent
+func (c *BookSQLContext) ReferencedImageURLs(callback func(string) error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	rows, err := c.conn.Query(ctx, `SELECT url FROM (
+		SELECT cover_url AS url FROM public.book
+		UNION SELECT previous_cover_url FROM public.book
+		UNION SELECT r.value->>'jpeg' FROM public.book, jsonb_each(COALESCE(cover_renditions, '{}')) r
+		UNION SELECT r.value->>'webp' FROM public.book, jsonb_each(COALESCE(cover_renditions, '{}')) r
+		UNION SELECT r.value->>'jpeg' FROM public.book, jsonb_each(COALESCE(previous_cover_renditions, '{}')) r
+		UNION SELECT r.value->>'webp' FROM public.book, jsonb_each(COALESCE(previous_cover_renditions, '{}')) r
+		UNION SELECT r.value->>'jpeg' FROM public."user", jsonb_each(COALESCE(avatar, '{}')) r
+		UNION SELECT r.value->>'webp' FROM public."user", jsonb_each(COALESCE(avatar, '{}')) r
+	) urls WHERE url IS NOT NULL AND url <> ''`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var url string
+		if err := rows.Scan(&url); err != nil {
+			return err
+		}
+		if err := callback(url); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
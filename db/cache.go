@@ -0,0 +1,47 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Persistence of the response cache of the catalog
+type CatalogCacheSQLContext struct {
+	conn *pgxpool.Pool
+}
+
+func NewSQLCatalogCacheContext(pool *pgxpool.Pool) *CatalogCacheSQLContext {
+	return &CatalogCacheSQLContext{
+		conn: pool,
+	}
+}
+
+func (c *CatalogCacheSQLContext) Load(ctx context.Context, key string) (*services.CachedResponse, error) {
+	var response services.CachedResponse
+	err := c.conn.QueryRow(ctx, `SELECT body, fetched_at FROM public.catalog_cache WHERE "key" = $1`, key).
+		Scan(&response.Body, &response.FetchedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, services.ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &response, nil
+}
+
+func (c *CatalogCacheSQLContext) Save(ctx context.Context, key string, response *services.CachedResponse) error {
+	_, err := c.conn.Exec(ctx, `INSERT INTO public.catalog_cache ("key", body, fetched_at) VALUES ($1, $2, $3)
+		ON CONFLICT ("key") DO UPDATE SET body = EXCLUDED.body, fetched_at = EXCLUDED.fetched_at`,
+		key, string(response.Body), response.FetchedAt)
+	return err
+}
+
+func (c *CatalogCacheSQLContext) Prune(ctx context.Context, before time.Time) error {
+	_, err := c.conn.Exec(ctx, `DELETE FROM public.catalog_cache WHERE fetched_at < $1`, before)
+	return err
+}
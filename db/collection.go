@@ -7,6 +7,7 @@ import (
 
 	"github.com/TheSgtPepper23/GreenLibrary/models"
 	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -20,12 +21,20 @@ func NewSQLCollectionContext(pool *pgxpool.Pool) *CollectionSQLContext {
 	}
 }
 
+// A collection with rules is smart, its books are the ones of the library of the owner that match them
 func (c *CollectionSQLContext) CreateCollection(collection *models.Collection) error {
+	if err := prepareRules(collection); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
+	collection.ID = services.GenerateUUID()
+	collection.CreationDate = time.Now()
 	_, err := c.conn.Exec(ctx, `INSERT INTO public.collection (
-		id, name, creation_date, owner_id, exclusive)
-		VALUES ($1, $2, $3, $4, $5)`, services.GenerateUUID(), collection.Name, time.Now(), collection.OwnerID, collection.Exclusive)
+		id, name, creation_date, owner_id, exclusive, rules)
+		VALUES ($1, $2, $3, $4, $5, $6)`, collection.ID, collection.Name, collection.CreationDate, collection.OwnerID,
+		collection.Exclusive, collection.Rules)
 
 	if err != nil {
 		return err
@@ -34,17 +43,43 @@ func (c *CollectionSQLContext) CreateCollection(collection *models.Collection) e
 	return nil
 }
 
+// The rules of a smart collection can change, but a manual collection can't become smart or the other way
+// around since its books would be lost
 func (c *CollectionSQLContext) UpdateCollection(collection *models.Collection) error {
+	if err := prepareRules(collection); err != nil {
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
 	defer cancel()
-	_, err := c.conn.Exec(ctx, `UPDATE
+
+	_, rules, err := collectionRules(c.conn, ctx, collection.ID)
+	if err != nil {
+		return err
+	}
+	if (rules != nil) != collection.Smart {
+		return ErrCollectionKind
+	}
+
+	_, err = c.conn.Exec(ctx, `UPDATE
 		public.collection
 		SET name = $1,
-		exclusive = $2
-		WHERE id=$3;`, collection.Name, collection.Exclusive, collection.ID)
+		exclusive = $2,
+		rules = $3
+		WHERE id=$4;`, collection.Name, collection.Exclusive, collection.Rules, collection.ID)
 	return err
 }
 
+// Validates the rules of the collection. The smart collections are never exclusive, a book can match many of them
+func prepareRules(collection *models.Collection) error {
+	collection.Smart = collection.Rules != nil
+	if !collection.Smart {
+		return nil
+	}
+	collection.Exclusive = false
+	return services.ValidateRules(collection.Rules)
+}
+
 func (c *CollectionSQLContext) GetCollections(ownerID string) (*[]models.Collection, error) {
 
 	collections := make([]models.Collection, 0)
@@ -54,7 +89,7 @@ func (c *CollectionSQLContext) GetCollections(ownerID string) (*[]models.Collect
 
 	rows, err := c.conn.Query(ctx, `SELECT
 		c.id, c.name, c.creation_date, c.owner_id, c.exclusive, c.read_col,
-		c.editable, COUNT(b.collection_id) as count FROM public.collection c LEFT JOIN public.collection_has_book b
+		c.editable, c.rules, COUNT(b.collection_id) as count FROM public.collection c LEFT JOIN public.collection_has_book b
 		on b.collection_id = c.id WHERE c.owner_id = $1 GROUP BY c.id, c.name ORDER BY c.creation_date desc`, ownerID)
 	if err != nil {
 		return nil, err
@@ -66,12 +101,33 @@ func (c *CollectionSQLContext) GetCollections(ownerID string) (*[]models.Collect
 		err := rows.Scan(&collection.ID, &collection.Name,
 			&collection.CreationDate, &collection.OwnerID,
 			&collection.Exclusive, &collection.ReadCol,
-			&collection.Editable, &collection.ContainedBooks)
+			&collection.Editable, &collection.Rules, &collection.ContainedBooks)
 		if err != nil {
 			return nil, err
 		}
+		collection.Smart = collection.Rules != nil
 		collections = append(collections, collection)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	//the smart collections have no rows of their own, their books are counted from the rules
+	for i := range collections {
+		if !collections[i].Smart {
+			continue
+		}
+		args := []any{ownerID}
+		cte, err := smartBooksCTE(collections[i].Rules, &args)
+		if err != nil {
+			return nil, err
+		}
+		err = c.conn.QueryRow(ctx, cte+` SELECT count(*) FROM smart`, args...).Scan(&collections[i].ContainedBooks)
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	return &collections, nil
 }
@@ -117,3 +173,55 @@ func (c *CollectionSQLContext) DeleteCollection(id string) error {
 
 	return nil
 }
+
+// Returns the ID of the collection where the user keeps the books already read. Every user gets one in the UserWizard
+func (c *CollectionSQLContext) GetReadCollectionID(ownerID string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var id string
+	err := c.conn.QueryRow(ctx, `SELECT id FROM public.collection WHERE owner_id = $1 AND read_col = true`, ownerID).Scan(&id)
+	return id, err
+}
+
+// Looks for a manual collection of the user by its name ignoring the case. Returns an empty ID if it doesn't exist
+func (c *CollectionSQLContext) FindCollection(ownerID, name string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var id string
+	err := c.conn.QueryRow(ctx, `SELECT id FROM public.collection WHERE owner_id = $1 AND lower(name) = lower($2)
+		AND rules IS NULL ORDER BY creation_date LIMIT 1`, ownerID, name).Scan(&id)
+	if err != nil && err != pgx.ErrNoRows {
+		return "", err
+	}
+	return id, nil
+}
+
+// Same as FindCollection, but the collection is created if it doesn't exist
+func (c *CollectionSQLContext) GetOrCreateCollection(ownerID, name string) (string, error) {
+	id, err := c.FindCollection(ownerID, name)
+	if err != nil || id != "" {
+		return id, err
+	}
+
+	collection := &models.Collection{Name: name, OwnerID: ownerID}
+	err = c.CreateCollection(collection)
+	return collection.ID, err
+}
+
+// Returns the collection without the count of its books
+func (c *CollectionSQLContext) GetCollection(id string) (*models.Collection, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	var collection models.Collection
+	err := c.conn.QueryRow(ctx, `SELECT id, name, creation_date, owner_id, exclusive, read_col, editable, rules
+		FROM public.collection WHERE id = $1`, id).Scan(&collection.ID, &collection.Name, &collection.CreationDate,
+		&collection.OwnerID, &collection.Exclusive, &collection.ReadCol, &collection.Editable, &collection.Rules)
+	if err != nil {
+		return nil, err
+	}
+	collection.Smart = collection.Rules != nil
+	return &collection, nil
+}
@@ -0,0 +1,464 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+var errAlreadyInLibrary = errors.New("book already in the library")
+
+type ImportSQLContext struct {
+	conn   *pgxpool.Pool
+	collDB *CollectionSQLContext
+}
+
+func NewSQLImportContext(pool *pgxpool.Pool) *ImportSQLContext {
+	return &ImportSQLContext{
+		conn:   pool,
+		collDB: NewSQLCollectionContext(pool),
+	}
+}
+
+// Stores every entry in the library of the user. The entries are independent from each other, so a failure
+// is reported in its result and the import continues with the next one. On a dry run nothing is written and
+// the report only previews the books and collections that would be created. The import stops between
+// entries once the context is done, the entries already stored are kept
+func (c *ImportSQLContext) ImportEntries(ctx context.Context, entries []models.ImportEntry, userID string, dryRun bool) (*models.ImportReport, error) {
+	run := &importRun{
+		userID:      userID,
+		dryRun:      dryRun,
+		collections: make(map[string]string),
+		report: &models.ImportReport{
+			DryRun:         dryRun,
+			NewCollections: make([]string, 0),
+			Results:        make([]models.ImportResult, 0, len(entries)),
+		},
+	}
+
+	for i, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("the import stopped after %d of %d entries: %w", i, len(entries), err)
+		}
+		result := models.ImportResult{Row: entry.Row, Title: entry.Title}
+
+		book, isNew, err := c.importEntry(entry, run)
+		switch {
+		case err == nil && dryRun:
+			result.Status = models.ImportPreview
+			if isNew {
+				result.Message = "Se agregará el libro al catálogo"
+			}
+		case err == nil:
+			result.Status = models.ImportCreated
+		case errors.Is(err, errAlreadyInLibrary):
+			result.Status = models.ImportSkipped
+			result.Message = "El libro ya se encuentra en la biblioteca"
+		case errors.Is(err, services.ErrBookNotFound):
+			result.Status = models.ImportFailed
+			result.Message = "No se encontró el libro en Open Library"
+		default:
+			fmt.Println(err.Error())
+			result.Status = models.ImportFailed
+			result.Message = "No es posible importar el libro"
+		}
+		if book != nil {
+			result.BookID = book.ID
+			result.BookKey = book.Key
+		}
+
+		run.report.Results = append(run.report.Results, result)
+	}
+
+	return run.report, nil
+}
+
+// state shared by the entries of a single import
+type importRun struct {
+	userID string
+	dryRun bool
+	//IDs of the collections already used, so every row of the same shelf reuses the first lookup
+	collections map[string]string
+	report      *models.ImportReport
+}
+
+func (c *ImportSQLContext) importEntry(entry models.ImportEntry, run *importRun) (*models.Book, bool, error) {
+	book, err := services.ResolveBook(entry.ISBNs, entry.Title, entry.Author)
+	if err != nil {
+		return nil, false, err
+	}
+
+	//the data from the export is kept when open library doesn't have it
+	if book.PageCount == 0 {
+		book.PageCount = entry.PageCount
+	}
+	if book.ReleaseYear == 0 {
+		book.ReleaseYear = entry.ReleaseYear
+	}
+	if book.AVGRating == 0 {
+		book.AVGRating = entry.AVGRating
+	}
+	book.MyRating = entry.MyRating
+	book.Comment = entry.Comment
+	book.DateAdded = entry.DateAdded
+	if book.DateAdded.IsZero() {
+		book.DateAdded = time.Now()
+	}
+	book.StartReading = entry.StartReading
+	book.FinishReading = entry.FinishReading
+	book.Tags = entry.Tags
+	book.Moods = entry.Moods
+
+	book.CollecionID, err = c.shelfCollection(entry.Shelf, run)
+	if err != nil {
+		return book, false, err
+	}
+
+	extraCollections := make([]string, 0, len(entry.Collections))
+	for _, name := range entry.Collections {
+		id, err := c.collectionByName(name, run)
+		if err != nil {
+			return book, false, err
+		}
+		if id != book.CollecionID {
+			extraCollections = append(extraCollections, id)
+		}
+	}
+
+	isNew, err := c.storeBook(book, extraCollections, run)
+	return book, isNew, err
+}
+
+func (c *ImportSQLContext) shelfCollection(shelf string, run *importRun) (string, error) {
+	switch shelf {
+	case models.ShelfRead:
+		if id, ok := run.collections[models.ShelfRead]; ok {
+			return id, nil
+		}
+		id, err := c.collDB.GetReadCollectionID(run.userID)
+		if err != nil {
+			return "", err
+		}
+		run.collections[models.ShelfRead] = id
+		return id, nil
+	case models.ShelfReading:
+		return c.collectionByName(models.CollectionReading, run)
+	case models.ShelfDidNotFinish:
+		return c.collectionByName(models.CollectionDidNotFinish, run)
+	case models.ShelfPaused:
+		return c.collectionByName(models.CollectionPaused, run)
+	case models.ShelfToRead, "":
+		return c.collectionByName(models.CollectionToRead, run)
+	default:
+		//custom exclusive shelves become collections with the same name
+		return c.collectionByName(shelf, run)
+	}
+}
+
+func (c *ImportSQLContext) collectionByName(name string, run *importRun) (string, error) {
+	key := "name:" + strings.ToLower(name)
+	if id, ok := run.collections[key]; ok {
+		return id, nil
+	}
+
+	var (
+		id  string
+		err error
+	)
+	if run.dryRun {
+		id, err = c.collDB.FindCollection(run.userID, name)
+		if err != nil {
+			return "", err
+		}
+		if id == "" {
+			//placeholder so the rest of the rows know the collection is already accounted for
+			id = "new:" + name
+			run.report.NewCollections = append(run.report.NewCollections, name)
+		}
+	} else {
+		id, err = c.collDB.GetOrCreateCollection(run.userID, name)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	run.collections[key] = id
+	return id, nil
+}
+
+// Stores the book and its shelf entries in a single transaction. Unlike CreateNewBook the dates, rating and
+// comment of the entry are preserved, since they come from the history of the user in another service.
+// Returns true when the book was not in the catalog before
+func (c *ImportSQLContext) storeBook(book *models.Book, extraCollections []string, run *importRun) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*15)
+	defer cancel()
+
+	existingID, err := validateBookIsStored(book.Key, c.conn)
+	if err != nil {
+		return false, err
+	}
+	isNew := existingID == ""
+
+	if existingID != "" {
+		inLibrary := false
+		err = c.conn.QueryRow(ctx, `SELECT EXISTS (SELECT 1
+			FROM public.collection_has_book chb
+			JOIN public.collection c ON c.id = chb.collection_id
+			WHERE chb.book_id = $1 AND c.owner_id = $2)`, existingID, run.userID).Scan(&inLibrary)
+		if err != nil {
+			return false, err
+		}
+		book.ID = existingID
+		if inLibrary {
+			return false, errAlreadyInLibrary
+		}
+	}
+
+	if run.dryRun {
+		return isNew, nil
+	}
+
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	if isNew {
+		err = insertBook(book, tx, ctx)
+		if err != nil {
+			tx.Rollback(ctx)
+			return false, err
+		}
+	}
+
+	_, err = tx.Exec(ctx, `INSERT INTO public.collection_has_book
+		(date_added, book_id, collection_id, rating, comment, start_reading, finish_reading, tags, moods)
+		VALUES($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		book.DateAdded, book.ID, book.CollecionID, book.MyRating, nullString(book.Comment),
+		nullTime(book.StartReading), nullTime(book.FinishReading), emptyIfNil(book.Tags), emptyIfNil(book.Moods))
+	if err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+
+	//same rule as markBookAsRead, a read book or a book in an exclusive shelf is not kept in other collections
+	exclusive := false
+	err = tx.QueryRow(ctx, `SELECT COALESCE(exclusive OR read_col, false) FROM public.collection WHERE id = $1`, book.CollecionID).Scan(&exclusive)
+	if err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+	if exclusive || !book.FinishReading.IsZero() {
+		extraCollections = nil
+	}
+
+	for _, collectionID := range extraCollections {
+		_, err = tx.Exec(ctx, `INSERT INTO public.collection_has_book (date_added, book_id, collection_id)
+			SELECT $1, $2, id FROM public.collection WHERE id = $3 AND NOT COALESCE(exclusive OR read_col, false)`,
+			book.DateAdded, book.ID, collectionID)
+		if err != nil {
+			tx.Rollback(ctx)
+			return false, err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return false, err
+	}
+
+	return isNew, nil
+}
+
+// Searches every ISBN of the list in the catalog and adds the books found to the collection. An ISBN is found
+// when the catalog returns a single book for it, or a single book whose ISBN matches, the rest are reported
+// as ambiguous with their candidates so the user picks the right one. Like ImportEntries, every ISBN is
+// independent and a failure is only reported in its result. The catalog is searched for several ISBNs at a
+// time, but the books are stored one by one. Fails when the context ends before every ISBN is resolved, the
+// books already stored are kept and they are skipped if the list is imported again
+func (c *ImportSQLContext) ImportISBNs(ctx context.Context, catalog *services.Catalog, payload models.ISBNImportJobPayload) (*models.ISBNImportReport, error) {
+	run := &importRun{userID: payload.UserID, collections: make(map[string]string)}
+	report := &models.ISBNImportReport{
+		CollectionID: payload.CollectionID,
+		Results:      make([]models.ISBNResult, 0, len(payload.Entries)+len(payload.Rejected)),
+	}
+
+	type resolved struct {
+		entry      models.ISBNEntry
+		book       *models.Book
+		candidates []models.Book
+		err        error
+	}
+	pending := make(chan models.ISBNEntry)
+	done := make(chan resolved)
+	var wg sync.WaitGroup
+	for i := 0; i < min(services.ISBNImportWorkers, len(payload.Entries)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for entry := range pending {
+				book, candidates, err := resolveISBN(ctx, catalog, entry)
+				done <- resolved{entry: entry, book: book, candidates: candidates, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(pending)
+		for _, entry := range payload.Entries {
+			select {
+			case pending <- entry:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	for current := range done {
+		entry := current.entry
+		result := models.ISBNResult{Line: entry.Line, Input: entry.Input, ISBN13: entry.ISBN13, ISBN10: entry.ISBN10}
+
+		book, candidates, err := current.book, current.candidates, current.err
+		switch {
+		case err != nil:
+			fmt.Println(err.Error())
+			result.Status = models.ISBNFailed
+			result.Message = "No fue posible consultar el catálogo"
+		case book == nil && len(candidates) == 0:
+			result.Status = models.ISBNNotFound
+			result.Message = "No se encontró el libro en el catálogo"
+		case book == nil:
+			result.Status = models.ISBNAmbiguous
+			result.Message = "El ISBN corresponde a más de un libro"
+			result.Candidates = candidates
+		default:
+			result.Status, result.Message = c.storeISBNBook(book, payload.CollectionID, run)
+			result.BookID = book.ID
+			result.BookKey = book.Key
+			result.Title = book.Title
+		}
+
+		report.Results = append(report.Results, result)
+	}
+
+	//the searches that ran out of time can't be told apart from a failure of the catalog, so with the context
+	//done a failed ISBN counts as not resolved
+	if err := ctx.Err(); err != nil {
+		finished := 0
+		for _, result := range report.Results {
+			if result.Status != models.ISBNFailed {
+				finished++
+			}
+		}
+		if finished < len(payload.Entries) {
+			return nil, fmt.Errorf("the import of the ISBNs stopped after %d of %d: %w", finished, len(payload.Entries), err)
+		}
+	}
+
+	//the rejected lines are kept in the report so it covers the whole list
+	report.Results = append(report.Results, payload.Rejected...)
+	sort.SliceStable(report.Results, func(i, j int) bool {
+		return report.Results[i].Line < report.Results[j].Line
+	})
+
+	for _, result := range report.Results {
+		switch result.Status {
+		case models.ISBNFound:
+			report.Found++
+		case models.ISBNAmbiguous:
+			report.Ambiguous++
+		case models.ISBNNotFound:
+			report.NotFound++
+		case models.ISBNInvalid, models.ISBNDuplicate:
+			report.Invalid++
+		case models.ISBNSkipped:
+			report.Skipped++
+		case models.ISBNFailed:
+			report.Failed++
+		}
+	}
+
+	return report, nil
+}
+
+// Maximum number of candidates reported for an ambiguous ISBN
+const maxISBNCandidates = 5
+
+// Returns the book of the ISBN, or the candidates when there is more than one. Fails only when every source
+// of the catalog failed, a partial answer is good enough for a list that the user can import again
+func resolveISBN(ctx context.Context, catalog *services.Catalog, entry models.ISBNEntry) (*models.Book, []models.Book, error) {
+	isbns := []string{entry.ISBN13}
+	if entry.ISBN10 != "" {
+		isbns = append(isbns, entry.ISBN10)
+	}
+
+	for _, isbn := range isbns {
+		results := catalog.Search(ctx, models.SearchQuery{ISBN: isbn, Page: 1, Limit: maxISBNCandidates})
+		failed := 0
+		for _, result := range results {
+			if result.Err != nil {
+				failed++
+			}
+		}
+
+		hits := services.MergeResults(results)
+		if len(hits) == 0 {
+			if failed > 0 && failed == len(results) {
+				return nil, nil, fmt.Errorf("the catalog could not be searched for %s", entry.ISBN13)
+			}
+			continue
+		}
+
+		books := services.RankHits("", hits)
+		var match *models.Book
+		matches := 0
+		for i := range books {
+			if books[i].ISBN == entry.ISBN13 || (entry.ISBN10 != "" && books[i].ISBN == entry.ISBN10) {
+				match = &books[i]
+				matches++
+			}
+		}
+		if len(books) == 1 {
+			match = &books[0]
+			matches = 1
+		}
+		if matches != 1 {
+			if len(books) > maxISBNCandidates {
+				books = books[:maxISBNCandidates]
+			}
+			return nil, books, nil
+		}
+
+		match.ISBN = entry.ISBN13
+		return match, nil, nil
+	}
+
+	return nil, nil, nil
+}
+
+func (c *ImportSQLContext) storeISBNBook(book *models.Book, collectionID string, run *importRun) (string, string) {
+	book.CollecionID = collectionID
+	book.DateAdded = time.Now()
+
+	_, err := c.storeBook(book, nil, run)
+	switch {
+	case err == nil:
+		return models.ISBNFound, ""
+	case errors.Is(err, errAlreadyInLibrary):
+		return models.ISBNSkipped, "El libro ya se encuentra en la biblioteca"
+	default:
+		fmt.Println(err.Error())
+		return models.ISBNFailed, "No es posible guardar el libro"
+	}
+}
@@ -0,0 +1,216 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+const (
+	defaultMaxAttempts = 5
+	baseBackoff        = 30 * time.Second
+	maxBackoff         = time.Hour
+	//a running job without updates for this long belongs to a worker that died
+	staleJobTimeout = 15 * time.Minute
+)
+
+var ErrJobNotFound = errors.New("job not found")
+
+const jobColumns = `id, kind, payload, result, status, COALESCE(owner_id, ''), attempts, max_attempts,
+	run_at, COALESCE(last_error, ''), created_at, updated_at`
+
+type JobSQLContext struct {
+	conn *pgxpool.Pool
+}
+
+func NewSQLJobContext(pool *pgxpool.Pool) *JobSQLContext {
+	return &JobSQLContext{
+		conn: pool,
+	}
+}
+
+// Adds a job to the queue, it will run as soon as a worker is free
+func (c *JobSQLContext) Enqueue(kind string, payload any, ownerID string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	return enqueueJob(c.conn, ctx, kind, payload, ownerID)
+}
+
+// pgx.Tx and pgxpool.Pool share this method, so a job can be enqueued as part of another transaction
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func enqueueJob(conn queryRower, ctx context.Context, kind string, payload any, ownerID string) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	row := conn.QueryRow(ctx, `INSERT INTO public.job (id, kind, payload, owner_id, max_attempts)
+		VALUES ($1, $2, $3, $4, $5) RETURNING `+jobColumns,
+		services.GenerateUUID(), kind, data, nullString(ownerID), defaultMaxAttempts)
+	return scanJob(row)
+}
+
+// Same as Enqueue, but nothing is added if there is already a pending or running job of the same kind.
+// Used by the scheduled jobs so many servers can share the queue without repeating the work
+func (c *JobSQLContext) EnqueueUnique(kind string, payload any) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := scanJob(c.conn.QueryRow(ctx, `INSERT INTO public.job (id, kind, payload, max_attempts)
+		SELECT $1::varchar, $2::varchar, $3::jsonb, $4::int
+		WHERE NOT EXISTS (SELECT 1 FROM public.job WHERE kind = $2 AND status IN ('pending', 'running'))
+		RETURNING `+jobColumns, services.GenerateUUID(), kind, data, defaultMaxAttempts))
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+// Takes the next pending job and marks it as running. The rows locked by other workers are skipped, so
+// many workers can claim at the same time without waiting for each other. Returns nil if the queue is empty
+func (c *JobSQLContext) Claim(ctx context.Context) (*models.Job, error) {
+	row := c.conn.QueryRow(ctx, `UPDATE public.job SET
+		status = 'running', attempts = attempts + 1, locked_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM public.job
+			WHERE status = 'pending' AND run_at <= now()
+			ORDER BY run_at
+			FOR UPDATE SKIP LOCKED
+			LIMIT 1)
+		RETURNING `+jobColumns)
+
+	job, err := scanJob(row)
+	if errors.Is(err, ErrJobNotFound) {
+		return nil, nil
+	}
+	return job, err
+}
+
+func (c *JobSQLContext) Complete(id string, result any) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+
+	_, err = c.conn.Exec(ctx, `UPDATE public.job SET status = 'done', result = $1, last_error = NULL,
+		locked_at = NULL, updated_at = now() WHERE id = $2`, data, id)
+	return err
+}
+
+// Schedules the job again with an exponential backoff, once the attempts run out or the error can't be
+// solved by retrying the job is marked as dead
+func (c *JobSQLContext) Fail(job *models.Job, jobErr error, retry bool) (models.JobStatus, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	status := models.JobPending
+	if !retry || job.Attempts >= job.MaxAttempts {
+		status = models.JobDead
+	}
+
+	_, err := c.conn.Exec(ctx, `UPDATE public.job SET status = $1, run_at = $2, last_error = $3,
+		locked_at = NULL, updated_at = now() WHERE id = $4`,
+		status, time.Now().Add(backoff(job.Attempts)), jobErr.Error(), job.ID)
+	return status, err
+}
+
+func backoff(attempts int) time.Duration {
+	wait := time.Duration(float64(baseBackoff) * math.Pow(2, float64(attempts-1)))
+	if wait > maxBackoff || wait <= 0 {
+		return maxBackoff
+	}
+	return wait
+}
+
+// Returns to the queue the jobs that were running when a worker stopped without finishing them
+func (c *JobSQLContext) RequeueStale() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	tag, err := c.conn.Exec(ctx, `UPDATE public.job SET status = 'pending', locked_at = NULL, updated_at = now()
+		WHERE status = 'running' AND locked_at < $1`, time.Now().Add(-staleJobTimeout))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (c *JobSQLContext) GetJob(id string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return scanJob(c.conn.QueryRow(ctx, `SELECT `+jobColumns+` FROM public.job WHERE id = $1`, id))
+}
+
+// Lists the most recent jobs, the empty filters are ignored
+func (c *JobSQLContext) ListJobs(status, kind string, ammount int) (*[]models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	rows, err := c.conn.Query(ctx, `SELECT `+jobColumns+` FROM public.job
+		WHERE ($1 = '' OR status = $1) AND ($2 = '' OR kind = $2)
+		ORDER BY updated_at DESC LIMIT $3`, status, kind, ammount)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	jobs := make([]models.Job, 0)
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+
+	return &jobs, rows.Err()
+}
+
+// Sends a dead job back to the queue with all its attempts available. The pending jobs are left alone, they
+// are still waiting for the backoff of their failed attempts
+func (c *JobSQLContext) RetryJob(id string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return scanJob(c.conn.QueryRow(ctx, `UPDATE public.job SET status = 'pending', attempts = 0, run_at = now(),
+		locked_at = NULL, updated_at = now() WHERE id = $1 AND status = 'dead'
+		RETURNING `+jobColumns, id))
+}
+
+func scanJob(row pgx.Row) (*models.Job, error) {
+	var (
+		job    models.Job
+		result []byte
+	)
+	err := row.Scan(&job.ID, &job.Kind, &job.Payload, &result, &job.Status, &job.OwnerID, &job.Attempts,
+		&job.MaxAttempts, &job.RunAt, &job.LastError, &job.CreatedAt, &job.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, ErrJobNotFound
+		}
+		return nil, err
+	}
+	job.Result = result
+
+	return &job, nil
+}
@@ -0,0 +1,184 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrBookNotInCollection = errors.New("book not in the collection")
+	ErrInvalidMove         = errors.New("a move needs the book and exactly one of before or after")
+)
+
+// order of the books of a collection sorted by hand, the entries without position go at the end
+const manualOrder = `chb.position ASC NULLS LAST, chb.date_added ASC, chb.id ASC`
+
+// Changes the positions of the books of a collection of the user. Only the moved entries are updated, unless
+// the collection has entries without position or there is no room between two of them, then the whole
+// collection is numbered again
+func (c *BookSQLContext) ReorderBooks(request *models.ReorderRequest, userID string) error {
+	for _, move := range request.Moves {
+		if move.BookID == "" || (move.Before == "") == (move.After == "") || move.BookID == move.Before || move.BookID == move.After {
+			return ErrInvalidMove
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*10)
+	defer cancel()
+
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	//the collection is locked so two reorders of the same collection don't pick the same positions
+	var (
+		ownerID string
+		rules   *models.CollectionRule
+	)
+	err = tx.QueryRow(ctx, `SELECT owner_id, rules FROM public.collection WHERE id = $1 FOR UPDATE`,
+		request.CollectionID).Scan(&ownerID, &rules)
+	if err == nil && ownerID != userID {
+		err = pgx.ErrNoRows
+	}
+	if err == nil && rules != nil {
+		err = ErrSmartCollection
+	}
+	if err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	if len(request.Order) > 0 {
+		if err := placeFirst(tx, ctx, request.CollectionID, request.Order); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+
+	for _, move := range request.Moves {
+		if err := moveBook(tx, ctx, request.CollectionID, move); err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		tx.Rollback(ctx)
+		return err
+	}
+
+	return nil
+}
+
+// Gives the listed books positions before the rest of the collection
+func placeFirst(tx pgx.Tx, ctx context.Context, collectionID string, bookIDs []string) error {
+	if err := renumberIfUnranked(tx, ctx, collectionID); err != nil {
+		return err
+	}
+
+	var first *float64
+	err := tx.QueryRow(ctx, `SELECT min(position) FROM public.collection_has_book
+		WHERE collection_id = $1 AND NOT (book_id::text = ANY($2))`, collectionID, bookIDs).Scan(&first)
+	if err != nil {
+		return err
+	}
+	start := 0.0
+	if first != nil {
+		start = *first - services.PositionGap*float64(len(bookIDs)+1)
+	}
+
+	for i, bookID := range bookIDs {
+		if err := setPosition(tx, ctx, collectionID, bookID, start+services.PositionGap*float64(i+1)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func moveBook(tx pgx.Tx, ctx context.Context, collectionID string, move models.ReorderMove) error {
+	if err := renumberIfUnranked(tx, ctx, collectionID); err != nil {
+		return err
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		prev, next, err := neighbors(tx, ctx, collectionID, move)
+		if err != nil {
+			return err
+		}
+		position, ok := services.PositionBetween(prev, next)
+		if ok {
+			return setPosition(tx, ctx, collectionID, move.BookID, position)
+		}
+		if err := renumberPositions(tx, ctx, collectionID); err != nil {
+			return err
+		}
+	}
+	return fmt.Errorf("no room to move the book %s", move.BookID)
+}
+
+// Positions around the place where the book goes, ignoring the book itself
+func neighbors(tx pgx.Tx, ctx context.Context, collectionID string, move models.ReorderMove) (*float64, *float64, error) {
+	anchorID := move.Before
+	if anchorID == "" {
+		anchorID = move.After
+	}
+
+	var anchor float64
+	err := tx.QueryRow(ctx, `SELECT position FROM public.collection_has_book
+		WHERE collection_id = $1 AND book_id::text = $2`, collectionID, anchorID).Scan(&anchor)
+	if err == pgx.ErrNoRows {
+		return nil, nil, ErrBookNotInCollection
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var other *float64
+	if move.Before != "" {
+		err = tx.QueryRow(ctx, `SELECT max(position) FROM public.collection_has_book
+			WHERE collection_id = $1 AND position < $2 AND book_id::text <> $3`, collectionID, anchor, move.BookID).Scan(&other)
+		return other, &anchor, err
+	}
+	err = tx.QueryRow(ctx, `SELECT min(position) FROM public.collection_has_book
+		WHERE collection_id = $1 AND position > $2 AND book_id::text <> $3`, collectionID, anchor, move.BookID).Scan(&other)
+	return &anchor, other, err
+}
+
+func setPosition(tx pgx.Tx, ctx context.Context, collectionID, bookID string, position float64) error {
+	tag, err := tx.Exec(ctx, `UPDATE public.collection_has_book SET position = $1
+		WHERE collection_id = $2 AND book_id::text = $3`, position, collectionID, bookID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrBookNotInCollection
+	}
+	return nil
+}
+
+// The entries without position have no neighbors to take a position from, so the first reorder of a
+// collection numbers all of them keeping the order shown to the user
+func renumberIfUnranked(tx pgx.Tx, ctx context.Context, collectionID string) error {
+	unranked := false
+	err := tx.QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM public.collection_has_book
+		WHERE collection_id = $1 AND position IS NULL)`, collectionID).Scan(&unranked)
+	if err != nil || !unranked {
+		return err
+	}
+	return renumberPositions(tx, ctx, collectionID)
+}
+
+func renumberPositions(tx pgx.Tx, ctx context.Context, collectionID string) error {
+	_, err := tx.Exec(ctx, `UPDATE public.collection_has_book target SET position = ranked.rank * $2::double precision
+		FROM (SELECT chb.id, row_number() OVER (ORDER BY `+manualOrder+`) AS rank
+			FROM public.collection_has_book chb WHERE chb.collection_id = $1) ranked
+		WHERE target.id = ranked.id`, collectionID, services.PositionGap)
+	return err
+}
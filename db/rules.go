@@ -0,0 +1,188 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	ErrSmartCollection = errors.New("the books of a smart collection come from its rules")
+	ErrCollectionKind  = errors.New("a collection can't change between manual and smart")
+)
+
+// Columns compared by the rules, the tables are aliased as in smartBooksCTE
+var ruleColumns = map[string]string{
+	models.RuleRating:       `chb.rating`,
+	models.RuleAVGRating:    `b.avg_rating`,
+	models.RulePageCount:    `b.page_count`,
+	models.RuleReleaseYear:  `b.release_year`,
+	models.RuleStartedYear:  `EXTRACT(YEAR FROM chb.start_reading)`,
+	models.RuleFinishedYear: `EXTRACT(YEAR FROM chb.finish_reading)`,
+	models.RuleTitle:        `public.library_unaccent(lower(b.title))`,
+	models.RuleAuthor:       `public.library_unaccent(lower(b.author))`,
+	models.RuleTag:          `chb.tags`,
+	models.RuleMood:         `chb.moods`,
+	models.RuleStatus: `CASE WHEN chb.finish_reading IS NOT NULL THEN '` + models.ShelfRead + `'
+		WHEN chb.start_reading IS NOT NULL THEN '` + models.ShelfReading + `' ELSE '` + models.ShelfToRead + `' END`,
+}
+
+var ruleComparisons = map[string]string{
+	models.OpEq:  "=",
+	models.OpNeq: "IS DISTINCT FROM",
+	models.OpGt:  ">",
+	models.OpGte: ">=",
+	models.OpLt:  "<",
+	models.OpLte: "<=",
+}
+
+// Id of the shelf entries of the user whose book matches the rules, one per book. A book can be in several
+// collections of the user, it matches if any of its entries does and the one of the exclusive collection is kept.
+// The owner is always $1
+func smartBooksCTE(rule *models.CollectionRule, args *[]any) (string, error) {
+	condition, err := compileRule(rule, args)
+	if err != nil {
+		return "", err
+	}
+
+	return `WITH smart AS (
+		SELECT DISTINCT ON (chb.book_id) chb.id
+		FROM public.collection_has_book chb
+		JOIN public.book b ON b.id = chb.book_id
+		JOIN public.collection c ON c.id = chb.collection_id
+		WHERE c.owner_id = $1 AND (` + condition + `)
+		ORDER BY chb.book_id, c.exclusive DESC, chb.date_added
+	)`, nil
+}
+
+// Builds the SQL condition of the rules, the values are appended to args as parameters
+func compileRule(rule *models.CollectionRule, args *[]any) (string, error) {
+	if rule.IsGroup() {
+		if len(rule.Rules) == 0 {
+			//an empty group doesn't restrict anything when all its rules must match
+			if rule.Match == models.MatchAny {
+				return "FALSE", nil
+			}
+			return "TRUE", nil
+		}
+
+		conditions := make([]string, 0, len(rule.Rules))
+		for i := range rule.Rules {
+			condition, err := compileRule(&rule.Rules[i], args)
+			if err != nil {
+				return "", err
+			}
+			conditions = append(conditions, "("+condition+")")
+		}
+		join := " AND "
+		if rule.Match == models.MatchAny {
+			join = " OR "
+		}
+		return strings.Join(conditions, join), nil
+	}
+
+	column, ok := ruleColumns[rule.Field]
+	if !ok {
+		return "", fmt.Errorf("%w: unknown field %q", services.ErrInvalidRules, rule.Field)
+	}
+	param := func(value any) string {
+		*args = append(*args, value)
+		return fmt.Sprintf("$%d", len(*args))
+	}
+
+	switch services.RuleFieldKind(rule.Field) {
+	case services.RuleList:
+		switch rule.Op {
+		case models.OpEq:
+			return fmt.Sprintf("%s::text = ANY(%s)", param(rule.Value), column), nil
+		case models.OpNeq:
+			return fmt.Sprintf("NOT (%s::text = ANY(%s))", param(rule.Value), column), nil
+		case models.OpIn:
+			return fmt.Sprintf("%s && %s::text[]", column, param(ruleStrings(rule.Value))), nil
+		}
+	case services.RuleText:
+		switch rule.Op {
+		case models.OpEq, models.OpNeq:
+			return fmt.Sprintf("%s %s public.library_unaccent(lower(%s::text))", column, ruleComparisons[rule.Op], param(rule.Value)), nil
+		case models.OpContains:
+			return fmt.Sprintf("%s LIKE '%%' || public.library_unaccent(lower(%s::text)) || '%%'", column, param(likePattern(rule.Value.(string)))), nil
+		case models.OpIn:
+			return fmt.Sprintf("%s = ANY(SELECT public.library_unaccent(lower(value)) FROM unnest(%s::text[]) value)",
+				column, param(ruleStrings(rule.Value))), nil
+		}
+	case services.RuleNumber:
+		if rule.Op == models.OpIn {
+			return fmt.Sprintf("%s = ANY(%s::numeric[])", column, param(ruleNumbers(rule.Value))), nil
+		}
+		if comparison, ok := ruleComparisons[rule.Op]; ok {
+			return fmt.Sprintf("%s %s %s::numeric", column, comparison, param(rule.Value)), nil
+		}
+	case services.RuleStatus:
+		switch rule.Op {
+		case models.OpEq, models.OpNeq:
+			return fmt.Sprintf("(%s) %s %s::text", column, ruleComparisons[rule.Op], param(rule.Value)), nil
+		case models.OpIn:
+			return fmt.Sprintf("(%s) = ANY(%s::text[])", column, param(ruleStrings(rule.Value))), nil
+		}
+	}
+
+	return "", fmt.Errorf("%w: %s doesn't support %q", services.ErrInvalidRules, rule.Field, rule.Op)
+}
+
+func ruleStrings(value any) []string {
+	values, _ := value.([]any)
+	result := make([]string, 0, len(values))
+	for _, value := range values {
+		if text, ok := value.(string); ok {
+			result = append(result, text)
+		}
+	}
+	return result
+}
+
+func ruleNumbers(value any) []float64 {
+	values, _ := value.([]any)
+	result := make([]float64, 0, len(values))
+	for _, value := range values {
+		if number, ok := value.(float64); ok {
+			result = append(result, number)
+		}
+	}
+	return result
+}
+
+// the text of a contains rule or a search filter is literal, so the wildcards of LIKE are escaped
+func likePattern(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+// Returns the owner and the rules of the collection, the rules are nil for the manual collections
+func collectionRules(conn queryRower, ctx context.Context, collectionID string) (string, *models.CollectionRule, error) {
+	var (
+		ownerID string
+		rules   *models.CollectionRule
+	)
+	err := conn.QueryRow(ctx, `SELECT owner_id, rules FROM public.collection WHERE id = $1`, collectionID).Scan(&ownerID, &rules)
+	return ownerID, rules, err
+}
+
+// Fails with ErrSmartCollection when the books of the collection can't be added or removed by hand.
+// A collection that doesn't exist is left to the constraints of the query that uses it
+func checkManualCollection(conn queryRower, ctx context.Context, collectionID string) error {
+	_, rules, err := collectionRules(conn, ctx, collectionID)
+	if err == pgx.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if rules != nil {
+		return ErrSmartCollection
+	}
+	return nil
+}
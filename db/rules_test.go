@@ -0,0 +1,140 @@
+package db
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+)
+
+func TestCompileRule(t *testing.T) {
+	leaf := func(field, op string, value any) models.CollectionRule {
+		return models.CollectionRule{Field: field, Op: op, Value: value}
+	}
+	status := ruleColumns[models.RuleStatus]
+
+	tests := []struct {
+		name     string
+		rule     models.CollectionRule
+		want     string
+		wantArgs []any
+		wantErr  bool
+	}{
+		{
+			name:     "number comparison",
+			rule:     leaf(models.RulePageCount, models.OpGt, 300.0),
+			want:     "b.page_count > $2::numeric",
+			wantArgs: []any{"owner", 300.0},
+		},
+		{
+			name:     "number distinct",
+			rule:     leaf(models.RuleRating, models.OpNeq, 5.0),
+			want:     "chb.rating IS DISTINCT FROM $2::numeric",
+			wantArgs: []any{"owner", 5.0},
+		},
+		{
+			name:     "number list",
+			rule:     leaf(models.RuleReleaseYear, models.OpIn, []any{1965.0, "x", 1984.0}),
+			want:     "b.release_year = ANY($2::numeric[])",
+			wantArgs: []any{"owner", []float64{1965, 1984}},
+		},
+		{
+			name:     "text equal",
+			rule:     leaf(models.RuleAuthor, models.OpEq, "Frank Herbert"),
+			want:     "public.library_unaccent(lower(b.author)) = public.library_unaccent(lower($2::text))",
+			wantArgs: []any{"owner", "Frank Herbert"},
+		},
+		{
+			name:     "text contains is literal",
+			rule:     leaf(models.RuleTitle, models.OpContains, `100%_\`),
+			want:     "public.library_unaccent(lower(b.title)) LIKE '%' || public.library_unaccent(lower($2::text)) || '%'",
+			wantArgs: []any{"owner", `100\%\_\\`},
+		},
+		{
+			name:     "tag",
+			rule:     leaf(models.RuleTag, models.OpNeq, "scifi"),
+			want:     "NOT ($2::text = ANY(chb.tags))",
+			wantArgs: []any{"owner", "scifi"},
+		},
+		{
+			name:     "mood list",
+			rule:     leaf(models.RuleMood, models.OpIn, []any{"dark", "tense"}),
+			want:     "chb.moods && $2::text[]",
+			wantArgs: []any{"owner", []string{"dark", "tense"}},
+		},
+		{
+			name:     "status",
+			rule:     leaf(models.RuleStatus, models.OpIn, []any{models.ShelfRead, models.ShelfReading}),
+			want:     "(" + status + ") = ANY($2::text[])",
+			wantArgs: []any{"owner", []string{models.ShelfRead, models.ShelfReading}},
+		},
+		{
+			name: "nested groups",
+			rule: models.CollectionRule{Match: models.MatchAll, Rules: []models.CollectionRule{
+				leaf(models.RuleAVGRating, models.OpGte, 4.0),
+				{Match: models.MatchAny, Rules: []models.CollectionRule{
+					leaf(models.RuleStartedYear, models.OpEq, 2023.0),
+					leaf(models.RuleFinishedYear, models.OpLt, 2020.0),
+				}},
+			}},
+			want: "(b.avg_rating >= $2::numeric) AND ((EXTRACT(YEAR FROM chb.start_reading) = $3::numeric) OR " +
+				"(EXTRACT(YEAR FROM chb.finish_reading) < $4::numeric))",
+			wantArgs: []any{"owner", 4.0, 2023.0, 2020.0},
+		},
+		{
+			name:     "empty group of all",
+			rule:     models.CollectionRule{Match: models.MatchAll},
+			want:     "TRUE",
+			wantArgs: []any{"owner"},
+		},
+		{
+			name:     "empty group of any",
+			rule:     models.CollectionRule{Match: models.MatchAny},
+			want:     "FALSE",
+			wantArgs: []any{"owner"},
+		},
+		{
+			name:    "unknown field",
+			rule:    leaf("isbn", models.OpEq, "123"),
+			wantErr: true,
+		},
+		{
+			name:    "unsupported operator",
+			rule:    leaf(models.RuleTitle, models.OpGt, "a"),
+			wantErr: true,
+		},
+		{
+			name: "error inside a group",
+			rule: models.CollectionRule{Match: models.MatchAny, Rules: []models.CollectionRule{
+				leaf(models.RuleRating, models.OpEq, 5.0),
+				leaf(models.RuleTag, models.OpGt, "a"),
+			}},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			//the owner is always the first parameter
+			args := []any{"owner"}
+			got, err := compileRule(&test.rule, &args)
+			if test.wantErr {
+				if !errors.Is(err, services.ErrInvalidRules) {
+					t.Fatalf("compileRule() error = %v, want ErrInvalidRules", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("compileRule() error = %v", err)
+			}
+			if got != test.want {
+				t.Errorf("compileRule() = %q, want %q", got, test.want)
+			}
+			if !reflect.DeepEqual(args, test.wantArgs) {
+				t.Errorf("args = %#v, want %#v", args, test.wantArgs)
+			}
+		})
+	}
+}
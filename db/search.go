@@ -0,0 +1,271 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+	"github.com/jackc/pgx/v5"
+)
+
+// the text compared by the trigram search, it matches the expression of book_search_trgm_idx
+const trigramText = `public.library_unaccent(b.title || ' ' || b.author)`
+
+// The joins and condition that limit a search to a collection or to the collections of the user, $1 is
+// the collection or the user
+func userSearchScope(collectionId, userKey string) (string, string) {
+	if collectionId != "" {
+		return `JOIN public.collection_has_book as chb ON b.id = chb.book_id
+			WHERE chb.collection_id = $1`, collectionId
+	}
+	return `JOIN public.collection_has_book as chb ON b.id = chb.book_id
+		JOIN public.collection c ON c.id = chb.collection_id
+		WHERE c.owner_id = $1`, userKey
+}
+
+// The books of the scope whose title or author contain something similar to the search term. The
+// threshold is set for the transaction only, so the index can be used with the <% operator
+func (c *BookSQLContext) fuzzyUserBooks(ctx context.Context, searchTerm, scope, scopeArg string) ([]models.Book, error) {
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	if err := setSimilarityThreshold(ctx, tx, c.fuzzy.Threshold); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT  b.id, b.title, b.author, b."key", b.author_key, b.release_year, chb.date_added,
+		chb.start_reading, chb.finish_reading, ` + coversColumn + `, ` + paletteColumn + `, chb.rating, chb."comment", b.avg_rating,
+		b.page_count, chb.collection_id, chb.tags, chb.moods FROM public.book as b ` + scope + `
+		AND public.library_unaccent($2) <% ` + trigramText + `
+		ORDER BY word_similarity(public.library_unaccent($2), ` + trigramText + `) DESC, b.title, b.id
+		LIMIT 20`
+
+	rows, err := tx.Query(ctx, query, scopeArg, searchTerm)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	books := make([]models.Book, 0)
+	if err := scanBooks(rows, &books); err != nil {
+		return nil, err
+	}
+	return books, rows.Err()
+}
+
+func setSimilarityThreshold(ctx context.Context, tx pgx.Tx, threshold float64) error {
+	_, err := tx.Exec(ctx, `SELECT set_config('pg_trgm.word_similarity_threshold', $1, true)`,
+		fmt.Sprint(threshold))
+	return err
+}
+
+// The same book can be in several collections of the user, so the entries are compared by book and collection
+func appendMissingBooks(books, extra []models.Book) []models.Book {
+	present := make(map[string]bool, len(books))
+	for _, book := range books {
+		present[book.ID+"|"+book.CollecionID] = true
+	}
+	for _, book := range extra {
+		if !present[book.ID+"|"+book.CollecionID] {
+			present[book.ID+"|"+book.CollecionID] = true
+			books = append(books, book)
+		}
+	}
+	return books
+}
+
+// The vocabulary of the catalog, kept by migration 013 with a trigram index
+const catalogVocabulary = `public.book_word`
+
+// The words of the titles and authors of the scope, computed on each search because the scope is small
+func scopeVocabulary(scope string) string {
+	return fmt.Sprintf(`(SELECT DISTINCT w.word FROM public.book as b
+		CROSS JOIN regexp_split_to_table(%s, '[^[:alnum:]]+') AS w(word) %s)`, trigramText, scope)
+}
+
+// Replaces every word of the search term with the most similar word of the vocabulary. It returns an empty
+// string when every word already exists or there's nothing similar enough. The threshold is set for the
+// transaction only, so the trigram index of the vocabulary can be used with the % operator
+func (c *BookSQLContext) spellingSuggestion(ctx context.Context, searchTerm, vocabulary string, scopeArgs ...any) (string, error) {
+	words := services.SearchWords(searchTerm)
+	if len(words) == 0 {
+		return "", nil
+	}
+
+	tx, err := c.conn.Begin(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, `SELECT set_config('pg_trgm.similarity_threshold', $1, true)`, fmt.Sprint(c.fuzzy.Threshold))
+	if err != nil {
+		return "", err
+	}
+
+	args := append(scopeArgs, words)
+	rows, err := tx.Query(ctx, fmt.Sprintf(`SELECT q.word, s.word
+		FROM unnest($%d::text[]) WITH ORDINALITY AS q(word, position)
+		LEFT JOIN LATERAL (
+			SELECT v.word FROM %s AS v WHERE v.word <> '' AND v.word %% q.word
+			ORDER BY v.word = q.word DESC, similarity(v.word, q.word) DESC, v.word
+			LIMIT 1
+		) s ON true
+		ORDER BY q.position`, len(args), vocabulary), args...)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	changed := false
+	suggestion := make([]string, 0, len(words))
+	for rows.Next() {
+		var (
+			word    string
+			similar *string
+		)
+		if err := rows.Scan(&word, &similar); err != nil {
+			return "", err
+		}
+		if similar == nil {
+			//a word that isn't in the library can't be corrected, so the whole suggestion is useless
+			return "", nil
+		}
+		changed = changed || *similar != word
+		suggestion = append(suggestion, *similar)
+	}
+	if err := rows.Err(); err != nil || !changed {
+		return "", err
+	}
+
+	return strings.Join(suggestion, " "), nil
+}
+
+// Updates the vocabulary used by the did-you-mean of the catalog with the books stored since the last time
+func (c *BookSQLContext) RefreshVocabulary(ctx context.Context) error {
+	_, err := c.conn.Exec(ctx, `REFRESH MATERIALIZED VIEW CONCURRENTLY public.book_word`)
+	return err
+}
+
+// True when a search found so few books that a correction of the term should be suggested
+func (c *BookSQLContext) FewResults(found int) bool {
+	return found < max(c.fuzzy.MinResults, 1)
+}
+
+// Suggests another search term when the search of the user finds few books
+func (c *BookSQLContext) UserSpellingSuggestion(searchTerm, collectionId, userKey string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	scope, scopeArg := userSearchScope(collectionId, userKey)
+	return c.spellingSuggestion(ctx, searchTerm, scopeVocabulary(scope), scopeArg)
+}
+
+// Suggests another search term using the words of every stored book, the vocabulary can miss the books
+// stored since its last refresh
+func (c *BookSQLContext) CatalogSpellingSuggestion(searchTerm string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+
+	return c.spellingSuggestion(ctx, searchTerm, catalogVocabulary)
+}
+
+// Books of the catalog ranked for a suggestion, a short prefix can match most of the catalog so only the
+// first matches found by the index are ranked
+const suggestCandidates = 200
+
+// Books and authors that start with what the user typed, the books in the shelves of the user go first.
+// The second value is false when there could be more suggestions than the limit
+func (c *BookSQLContext) SuggestBooks(ctx context.Context, searchTerm, userKey string, limit int) ([]models.Suggestion, bool, error) {
+	suggestions := make([]models.Suggestion, 0)
+	text := services.WebSearchQuery(searchTerm)
+	if text == "" {
+		return suggestions, true, nil
+	}
+
+	//the books of the user are few, so all the ones that match can be ranked
+	shelved, err := c.suggestedBooks(ctx, `SELECT b.id, b."key", b.title, b.author,
+			COALESCE(b.cover_renditions->'thumbnail'->>'jpeg', b.cover_url, ''), true
+		FROM public.book b
+		WHERE b.id IN (SELECT chb.book_id FROM public.collection_has_book chb
+				JOIN public.collection c ON c.id = chb.collection_id WHERE c.owner_id = $2)
+			AND b.search_vector @@ public.library_query($1)
+		ORDER BY ts_rank(b.search_vector, public.library_query($1)) DESC, b.title, b.id
+		LIMIT $3`, text, userKey, limit)
+	if err != nil {
+		return nil, false, err
+	}
+	suggestions = append(suggestions, shelved...)
+
+	//every book of the user that matches was found, so the catalog only has to leave those out
+	if len(suggestions) < limit {
+		ids := make([]string, 0, len(shelved))
+		for _, suggestion := range shelved {
+			ids = append(ids, suggestion.ID)
+		}
+		catalog, err := c.suggestedBooks(ctx, `SELECT b.id, b."key", b.title, b.author, b.thumbnail, false
+			FROM (SELECT b.id, b."key", b.title, b.author, b.search_vector,
+					COALESCE(b.cover_renditions->'thumbnail'->>'jpeg', b.cover_url, '') AS thumbnail
+				FROM public.book b
+				WHERE b.search_vector @@ public.library_query($1) AND b.id::text <> ALL($2::text[])
+				LIMIT $4) b
+			ORDER BY ts_rank(b.search_vector, public.library_query($1)) DESC, b.title, b.id
+			LIMIT $3`, text, ids, limit-len(suggestions), suggestCandidates)
+		if err != nil {
+			return nil, false, err
+		}
+		suggestions = append(suggestions, catalog...)
+	}
+	complete := len(suggestions) < limit
+
+	//the index finds the candidates and only the ones whose author matches are kept
+	authorLimit := max(limit/3, 1)
+	rows, err := c.conn.Query(ctx, `SELECT b.author FROM public.book b
+		WHERE b.search_vector @@ public.library_query($1)
+			AND to_tsvector('public.library_simple'::regconfig, b.author) @@ to_tsquery('public.library_simple'::regconfig, $1)
+		GROUP BY b.author ORDER BY count(*) DESC, b.author LIMIT $2`, text, authorLimit)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+	authors := 0
+	for rows.Next() {
+		suggestion := models.Suggestion{Kind: models.SuggestionAuthor}
+		if err := rows.Scan(&suggestion.Author); err != nil {
+			return nil, false, err
+		}
+		suggestion.Text = suggestion.Author
+		suggestions = append(suggestions, suggestion)
+		authors++
+	}
+	complete = complete && authors < authorLimit
+
+	return suggestions, complete, rows.Err()
+}
+
+func (c *BookSQLContext) suggestedBooks(ctx context.Context, query string, args ...any) ([]models.Suggestion, error) {
+	rows, err := c.conn.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	suggestions := make([]models.Suggestion, 0)
+	for rows.Next() {
+		suggestion := models.Suggestion{Kind: models.SuggestionBook}
+		err := rows.Scan(&suggestion.ID, &suggestion.Key, &suggestion.Title, &suggestion.Author,
+			&suggestion.Thumbnail, &suggestion.Shelved)
+		if err != nil {
+			return nil, err
+		}
+		suggestion.Text = suggestion.Title
+		suggestions = append(suggestions, suggestion)
+	}
+	return suggestions, rows.Err()
+}
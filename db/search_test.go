@@ -0,0 +1,44 @@
+package db
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+)
+
+// Needs a database with books in CONN_STRING, e.g.
+// CONN_STRING=postgres://... go test ./db -run '^$' -bench SuggestBooks
+// The short prefixes match most of the catalog, they must take about as long as the long ones
+func BenchmarkSuggestBooks(b *testing.B) {
+	if os.Getenv("CONN_STRING") == "" {
+		b.Skip("CONN_STRING is not set")
+	}
+	pool, err := GetConnection()
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer pool.Close()
+
+	ctx := context.Background()
+	var userKey string
+	err = pool.QueryRow(ctx, `SELECT owner_id FROM public.collection c
+		JOIN public.collection_has_book chb ON chb.collection_id = c.id
+		GROUP BY owner_id ORDER BY count(*) DESC LIMIT 1`).Scan(&userKey)
+	if err != nil {
+		b.Skip("there are no books in the collections: ", err)
+	}
+	bookDB := NewSQLBookContext(pool)
+
+	for _, query := range []string{"th", "la", "harry pot", "cien años de sol"} {
+		b.Run(query, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, _, err := bookDB.SuggestBooks(ctx, query, userKey, services.SuggestLimit)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -77,7 +77,7 @@ func (c *UserSQLContext) UserWizard(email, password string) error {
 
 	_, err = tx.Exec(ctx,
 		`INSERT INTO public.collection (id, name, creation_date, owner_id, editable) VALUES ($1, $2, $3, $4, $5)`,
-		services.GenerateUUID(), "Por leer", time.Now(), userId, false)
+		services.GenerateUUID(), models.CollectionToRead, time.Now(), userId, false)
 	if err != nil {
 		tx.Rollback(ctx)
 		return err
@@ -96,3 +96,11 @@ func hashPassword(password string) string {
 	hasher.Write([]byte(password))
 	return hex.EncodeToString(hasher.Sum(nil))
 }
+
+func (c *UserSQLContext) UpdateAvatar(userID string, avatar models.Covers) error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+
+	_, err := c.conn.Exec(ctx, `UPDATE public.user SET avatar = $1 WHERE id = $2`, avatar, userID)
+	return err
+}
@@ -0,0 +1,26 @@
+package db
+
+import "time"
+
+// pgx writes nil pointers as NULL, these helpers keep the zero values out of the database
+func nullString(value string) *string {
+	if value == "" {
+		return nil
+	}
+	return &value
+}
+
+func nullTime(value time.Time) *time.Time {
+	if value.IsZero() {
+		return nil
+	}
+	return &value
+}
+
+// the array columns are NOT NULL, while pgx writes a nil slice as NULL
+func emptyIfNil(values []string) []string {
+	if values == nil {
+		return []string{}
+	}
+	return values
+}
@@ -0,0 +1,143 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/db"
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+)
+
+const (
+	defaultGCInterval   = time.Hour * 24
+	defaultGCGrace      = time.Hour * 72
+	defaultGCOrphanRate = 0.5
+)
+
+var (
+	ErrBaseURLChanged = errors.New("no image in use starts with the base URL of the storage")
+	ErrTooManyOrphans = errors.New("too many orphans")
+)
+
+// Interval of the scheduled collection, from IMAGE_GC_INTERVAL (e.g. 12h)
+func GCInterval() time.Duration {
+	return durationFromEnv("IMAGE_GC_INTERVAL", defaultGCInterval)
+}
+
+// Age an orphan must reach before it is deleted, from IMAGE_GC_GRACE (e.g. 72h). Protects the images of
+// a book whose insert has not finished yet
+func GCGrace() time.Duration {
+	return durationFromEnv("IMAGE_GC_GRACE", defaultGCGrace)
+}
+
+// Share of the stored images that can be deleted in a single collection, from IMAGE_GC_MAX_ORPHANS (e.g. 0.5).
+// More orphans than that usually mean the images in use were not recognized, so nothing is deleted
+func GCMaxOrphanRate() float64 {
+	value, err := strconv.ParseFloat(os.Getenv("IMAGE_GC_MAX_ORPHANS"), 64)
+	if err != nil || value <= 0 || value > 1 {
+		return defaultGCOrphanRate
+	}
+	return value
+}
+
+func durationFromEnv(name string, fallback time.Duration) time.Duration {
+	value, err := time.ParseDuration(os.Getenv(name))
+	if err != nil || value <= 0 {
+		return fallback
+	}
+	return value
+}
+
+// Compares the blobs of the storage with the images used by the books and users. The orphans older than
+// the grace period are deleted unless it is a dry run, the missing images are only reported. Nothing is
+// deleted when the orphans are more than maxOrphanRate of the blobs
+func CollectImages(ctx context.Context, bookDB *db.BookSQLContext, store services.BlobStore, grace time.Duration, maxOrphanRate float64, dryRun bool) (*models.ImageGCReport, error) {
+	report := &models.ImageGCReport{
+		DryRun:  dryRun,
+		Orphans: make([]string, 0),
+		Missing: make([]string, 0),
+	}
+
+	//the images are matched by the end of their URL, which is their key, so a new base URL doesn't turn them
+	//into orphans. The base is still checked, if none of the images uses it the configuration is wrong
+	base := store.URL("")
+	referenced := make(map[string]string)
+	withBase := 0
+	err := bookDB.ReferencedImageURLs(func(url string) error {
+		key, ok := services.ImageKeyOf(url)
+		if !ok {
+			return nil
+		}
+		referenced[key] = url
+		if base != "" && strings.HasPrefix(url, base) {
+			withBase++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(referenced) > 0 && withBase == 0 {
+		return nil, fmt.Errorf("%w %q, check IMG_URL or the public URL of the bucket", ErrBaseURLChanged, base)
+	}
+
+	limit := time.Now().Add(-grace)
+	orphans := make([]string, 0)
+	found := make(map[string]bool, len(referenced))
+	for _, prefix := range services.ImagePrefixes {
+		err = store.List(ctx, prefix+"/", func(blob services.BlobInfo) error {
+			report.Scanned++
+			if _, ok := referenced[blob.Key]; ok {
+				found[blob.Key] = true
+				return nil
+			}
+
+			report.Orphans = append(report.Orphans, blob.Key)
+			if blob.LastModified.After(limit) {
+				report.Recent++
+				return nil
+			}
+			orphans = append(orphans, blob.Key)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for key, url := range referenced {
+		if !found[key] {
+			report.Missing = append(report.Missing, url)
+		}
+	}
+
+	if dryRun {
+		return report, nil
+	}
+	if float64(len(orphans)) > maxOrphanRate*float64(report.Scanned) {
+		return report, fmt.Errorf("%w: %d of %d images, the limit is %.0f%%", ErrTooManyOrphans, len(orphans), report.Scanned, maxOrphanRate*100)
+	}
+
+	//deleted after the listing so the storage is not modified while it is being read
+	for _, key := range orphans {
+		if err := store.Delete(ctx, key); err != nil {
+			return report, fmt.Errorf("deleting %s: %w", key, err)
+		}
+		report.Deleted++
+	}
+
+	return report, nil
+}
+
+// Scheduled version of CollectImages
+func ImageGC(bookDB *db.BookSQLContext, store services.BlobStore) Handler {
+	return Typed(func(ctx context.Context, job *models.Job, payload models.ImageGCPayload) (any, error) {
+		return CollectImages(ctx, bookDB, store, GCGrace(), GCMaxOrphanRate(), payload.DryRun)
+	})
+}
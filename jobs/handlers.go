@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/db"
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+)
+
+const defaultVocabularyInterval = time.Hour
+
+// Downloads the cover of a book that was just stored and points the book to the local copy. The books
+// without a cover, or whose cover doesn't exist anymore, get a placeholder. When the download can't succeed
+// the cover is marked as failed so an admin can fetch it again
+func CoverDownload(bookDB *db.BookSQLContext, store services.BlobStore) Handler {
+	return Typed(func(ctx context.Context, job *models.Job, payload models.CoverJobPayload) (any, error) {
+		var (
+			cover models.StoredCover
+			err   error
+		)
+		//without network the placeholder is used, the real cover can be fetched again later
+		remote := payload.URL != "" && !services.OfflineMode()
+		if remote {
+			cover.Covers, cover.CoverPalette, err = services.ProcessImage(ctx, store, payload.URL)
+		}
+		if !remote || errors.Is(err, services.ErrImageNotFound) {
+			cover.Covers, cover.CoverPalette, err = services.ProcessPlaceholder(ctx, store, payload.Title, payload.Author, payload.BookKey)
+		}
+
+		if err != nil {
+			if services.IsPermanentImageError(err) {
+				err = Permanent(err)
+			}
+			if LastAttempt(job, err) {
+				if statusErr := bookDB.SetCoverStatus(payload.BookKey, models.CoverFailed); statusErr != nil {
+					services.PrintRedError(statusErr.Error())
+				}
+			}
+			return nil, err
+		}
+		return cover, bookDB.UpdateCovers(payload.BookKey, &cover)
+	})
+}
+
+// Runs an import uploaded by a user, the report is kept as the result of the job. An import that runs out
+// of time is not retried, like the ISBN imports
+func ImportLibrary(importDB *db.ImportSQLContext) Handler {
+	return Typed(func(ctx context.Context, job *models.Job, payload models.ImportJobPayload) (any, error) {
+		report, err := importDB.ImportEntries(ctx, payload.Entries, payload.UserID, false)
+		if err != nil {
+			return nil, Permanent(err)
+		}
+		return report, nil
+	})
+}
+
+// Resolves a list of ISBNs through the catalog and adds the books to a collection of the user. An import
+// that runs out of time is not retried, the user can send the list again and the books stored are skipped
+func ImportISBNs(importDB *db.ImportSQLContext, catalog *services.Catalog) Handler {
+	return Typed(func(ctx context.Context, job *models.Job, payload models.ISBNImportJobPayload) (any, error) {
+		report, err := importDB.ImportISBNs(ctx, catalog, payload)
+		if err != nil {
+			return nil, Permanent(err)
+		}
+		return report, nil
+	})
+}
+
+// Interval of the refresh of the search vocabulary, from SEARCH_VOCABULARY_INTERVAL (e.g. 30m)
+func VocabularyInterval() time.Duration {
+	return durationFromEnv("SEARCH_VOCABULARY_INTERVAL", defaultVocabularyInterval)
+}
+
+// Adds the words of the books stored since the last refresh to the did-you-mean of the catalog
+func RefreshVocabulary(bookDB *db.BookSQLContext) Handler {
+	return Typed(func(ctx context.Context, job *models.Job, payload struct{}) (any, error) {
+		return nil, bookDB.RefreshVocabulary(ctx)
+	})
+}
@@ -0,0 +1,174 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/db"
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/TheSgtPepper23/GreenLibrary/services"
+)
+
+const (
+	pollInterval = time.Second * 2
+	jobTimeout   = time.Minute * 10
+)
+
+// Processes a job and returns the value stored as its result
+type Handler func(ctx context.Context, job *models.Job) (any, error)
+
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// Marks an error as impossible to solve by retrying, the job is sent to the dead state right away
+func Permanent(err error) error {
+	return &permanentError{err: err}
+}
+
+// True when the job won't run again after this attempt fails
+func LastAttempt(job *models.Job, err error) bool {
+	var permanent *permanentError
+	return errors.As(err, &permanent) || job.Attempts >= job.MaxAttempts
+}
+
+// Wraps a handler that receives the payload already decoded, so every kind of job declares its own type
+func Typed[T any](handler func(ctx context.Context, job *models.Job, payload T) (any, error)) Handler {
+	return func(ctx context.Context, job *models.Job) (any, error) {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return nil, Permanent(fmt.Errorf("invalid payload: %w", err))
+		}
+		return handler(ctx, job, payload)
+	}
+}
+
+type Pool struct {
+	jobDB    *db.JobSQLContext
+	workers  int
+	handlers map[string]Handler
+	wg       sync.WaitGroup
+}
+
+func NewPool(jobDB *db.JobSQLContext, workers int) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		jobDB:    jobDB,
+		workers:  workers,
+		handlers: make(map[string]Handler),
+	}
+}
+
+// Handlers must be registered before calling Start
+func (p *Pool) Register(kind string, handler Handler) {
+	p.handlers[kind] = handler
+}
+
+// Starts the workers, they stop when the context is cancelled
+func (p *Pool) Start(ctx context.Context) {
+	requeued, err := p.jobDB.RequeueStale()
+	if err != nil {
+		services.PrintRedError(err.Error())
+	} else if requeued > 0 {
+		fmt.Printf("%d stale jobs returned to the queue\n", requeued)
+	}
+
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go p.work(ctx)
+	}
+}
+
+// Enqueues a job of the kind every interval, unless the previous one is still waiting or running
+func (p *Pool) Schedule(ctx context.Context, kind string, interval time.Duration, payload any) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := p.jobDB.EnqueueUnique(kind, payload); err != nil {
+					services.PrintRedError(err.Error())
+				}
+			}
+		}
+	}()
+}
+
+// Waits until every worker finishes its current job
+func (p *Pool) Wait() {
+	p.wg.Wait()
+}
+
+func (p *Pool) work(ctx context.Context) {
+	defer p.wg.Done()
+
+	for {
+		job, err := p.jobDB.Claim(ctx)
+		if err != nil {
+			services.PrintRedError(err.Error())
+		}
+		if job == nil {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(pollInterval):
+				continue
+			}
+		}
+
+		p.run(ctx, job)
+	}
+}
+
+func (p *Pool) run(ctx context.Context, job *models.Job) {
+	result, err := p.execute(ctx, job)
+	if err != nil {
+		var permanent *permanentError
+		status, failErr := p.jobDB.Fail(job, err, !errors.As(err, &permanent))
+		if failErr != nil {
+			services.PrintRedError(failErr.Error())
+			return
+		}
+		services.PrintRedError(fmt.Sprintf("job %s (%s) failed on attempt %d, now %s: %s",
+			job.ID, job.Kind, job.Attempts, status, err.Error()))
+		return
+	}
+
+	if err := p.jobDB.Complete(job.ID, result); err != nil {
+		services.PrintRedError(err.Error())
+	}
+}
+
+// a panic in a handler fails the job instead of stopping the server
+func (p *Pool) execute(ctx context.Context, job *models.Job) (result any, err error) {
+	handler, ok := p.handlers[job.Kind]
+	if !ok {
+		return nil, fmt.Errorf("no handler registered for %q", job.Kind)
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+
+	return handler(ctx, job)
+}
@@ -0,0 +1,3 @@
+-- ISBN of the edition, used by the imports to match the books of other services
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS isbn varchar(13);
+CREATE INDEX IF NOT EXISTS book_isbn_idx ON public.book (isbn);
@@ -0,0 +1,3 @@
+-- Per user labels of a shelf entry, imported from The StoryGraph
+ALTER TABLE public.collection_has_book ADD COLUMN IF NOT EXISTS tags text[] NOT NULL DEFAULT '{}';
+ALTER TABLE public.collection_has_book ADD COLUMN IF NOT EXISTS moods text[] NOT NULL DEFAULT '{}';
@@ -0,0 +1,19 @@
+-- Durable queue of the background jobs, the workers take them with SELECT ... FOR UPDATE SKIP LOCKED
+CREATE TABLE IF NOT EXISTS public.job (
+	id varchar(36) PRIMARY KEY,
+	kind varchar(64) NOT NULL,
+	payload jsonb NOT NULL DEFAULT '{}',
+	result jsonb,
+	status varchar(16) NOT NULL DEFAULT 'pending',
+	owner_id varchar(36),
+	attempts int NOT NULL DEFAULT 0,
+	max_attempts int NOT NULL DEFAULT 5,
+	run_at timestamptz NOT NULL DEFAULT now(),
+	last_error text,
+	locked_at timestamptz,
+	created_at timestamptz NOT NULL DEFAULT now(),
+	updated_at timestamptz NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS job_pending_idx ON public.job (run_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS job_status_idx ON public.job (status, updated_at DESC);
@@ -0,0 +1,6 @@
+-- State of the local copy of the cover and the URL it was downloaded from, so failed covers can be fetched again
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS cover_source_url text;
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS cover_status varchar(16) NOT NULL DEFAULT 'stored';
+ALTER TABLE public.book ALTER COLUMN cover_status SET DEFAULT 'pending';
+UPDATE public.book SET cover_source_url = cover_url WHERE cover_source_url IS NULL;
+CREATE INDEX IF NOT EXISTS book_cover_failed_idx ON public.book (cover_status) WHERE cover_status = 'failed';
@@ -0,0 +1,2 @@
+-- URLs of every size and format of the stored cover, cover_url keeps the biggest JPEG
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS cover_renditions jsonb;
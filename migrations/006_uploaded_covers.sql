@@ -0,0 +1,6 @@
+-- Cover replaced by the last upload, kept to roll it back
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS previous_cover_url text;
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS previous_cover_renditions jsonb;
+
+-- Renditions of the avatar uploaded by the user
+ALTER TABLE public."user" ADD COLUMN IF NOT EXISTS avatar jsonb;
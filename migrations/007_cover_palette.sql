@@ -0,0 +1,3 @@
+-- BlurHash, dominant and accent colors of the cover, computed when the renditions are generated
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS cover_palette jsonb;
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS previous_cover_palette jsonb;
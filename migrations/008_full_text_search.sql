@@ -0,0 +1,43 @@
+-- Full text search of the books that ignores accents and understands spanish and english.
+-- unaccent is a filtering dictionary, it removes the accents and passes the word to the stemmer
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+DO $$
+BEGIN
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'library_simple') THEN
+		CREATE TEXT SEARCH CONFIGURATION public.library_simple (COPY = pg_catalog.simple);
+		ALTER TEXT SEARCH CONFIGURATION public.library_simple
+			ALTER MAPPING FOR hword, hword_part, word WITH unaccent, simple;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'library_es') THEN
+		CREATE TEXT SEARCH CONFIGURATION public.library_es (COPY = pg_catalog.spanish);
+		ALTER TEXT SEARCH CONFIGURATION public.library_es
+			ALTER MAPPING FOR hword, hword_part, word WITH unaccent, spanish_stem;
+	END IF;
+	IF NOT EXISTS (SELECT 1 FROM pg_ts_config WHERE cfgname = 'library_en') THEN
+		CREATE TEXT SEARCH CONFIGURATION public.library_en (COPY = pg_catalog.english);
+		ALTER TEXT SEARCH CONFIGURATION public.library_en
+			ALTER MAPPING FOR hword, hword_part, word WITH unaccent, english_stem;
+	END IF;
+END
+$$;
+
+-- The title is indexed with the three configurations, so a word matches as written, stemmed in spanish
+-- or stemmed in english. The author is never stemmed
+ALTER TABLE public.book ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('public.library_simple'::regconfig, coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('public.library_es'::regconfig, coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('public.library_en'::regconfig, coalesce(title, '')), 'A') ||
+	setweight(to_tsvector('public.library_simple'::regconfig, coalesce(author, '')), 'B')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS book_search_vector_idx ON public.book USING gin (search_vector);
+
+-- Receives the text built by the server (terms joined by &, | and !, with :* for the prefixes) and
+-- matches it with any of the configurations of the column
+CREATE OR REPLACE FUNCTION public.library_query(query text) RETURNS tsquery
+LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT AS $$
+	SELECT to_tsquery('public.library_simple'::regconfig, query) ||
+		to_tsquery('public.library_es'::regconfig, query) ||
+		to_tsquery('public.library_en'::regconfig, query)
+$$;
@@ -0,0 +1,12 @@
+-- Trigram search used when the full text search finds few books, it tolerates typos like
+-- Dostoyevsky instead of Dostoevsky
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- unaccent is only stable because its dictionary could change, the index needs an immutable function
+CREATE OR REPLACE FUNCTION public.library_unaccent(value text) RETURNS text
+LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT AS $$
+	SELECT lower(public.unaccent('public.unaccent'::regdictionary, value))
+$$;
+
+CREATE INDEX IF NOT EXISTS book_search_trgm_idx ON public.book
+	USING gin (public.library_unaccent(title || ' ' || author) gin_trgm_ops);
@@ -0,0 +1,8 @@
+-- Responses of the external catalogs, kept so the cache survives a restart
+CREATE TABLE IF NOT EXISTS public.catalog_cache (
+	"key" text PRIMARY KEY,
+	body jsonb NOT NULL,
+	fetched_at timestamptz NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS catalog_cache_fetched_at_idx ON public.catalog_cache (fetched_at);
@@ -0,0 +1,3 @@
+-- Rules of the smart collections, the manual collections keep it null. The books of a smart collection
+-- are computed from the rules, so they never have rows in collection_has_book
+ALTER TABLE public.collection ADD COLUMN IF NOT EXISTS rules jsonb;
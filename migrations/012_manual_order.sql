@@ -0,0 +1,6 @@
+-- Position chosen by the user for the books of a collection. The entries never moved keep it null and
+-- go after the rest, so new books don't need a position until the user reorders the collection
+ALTER TABLE public.collection_has_book ADD COLUMN IF NOT EXISTS position double precision;
+
+CREATE INDEX IF NOT EXISTS collection_has_book_position_idx
+	ON public.collection_has_book (collection_id, position);
@@ -0,0 +1,11 @@
+-- Words of the titles and authors of every book, the did-you-mean of the catalog compares the search
+-- with this table instead of splitting every book on each search. It is refreshed by a scheduled job
+CREATE MATERIALIZED VIEW IF NOT EXISTS public.book_word AS
+	SELECT DISTINCT w.word
+	FROM public.book b,
+		regexp_split_to_table(public.library_unaccent(b.title || ' ' || b.author), '[^[:alnum:]]+') AS w(word)
+	WHERE w.word <> '';
+
+-- the unique index allows refreshing the view concurrently, without blocking the searches
+CREATE UNIQUE INDEX IF NOT EXISTS book_word_word_idx ON public.book_word (word);
+CREATE INDEX IF NOT EXISTS book_word_trgm_idx ON public.book_word USING gin (word gin_trgm_ops);
@@ -0,0 +1,15 @@
+-- 004 marked every book as stored, but the books added before the image pipeline still point to the
+-- cover of the provider and have no local renditions. They are marked as pending and their download is
+-- queued, like the books stored since then
+UPDATE public.book SET cover_status = 'pending'
+WHERE cover_status = 'stored' AND cover_renditions IS NULL AND COALESCE(cover_url, '') <> '';
+
+INSERT INTO public.job (id, kind, payload, max_attempts)
+SELECT gen_random_uuid()::text, 'cover.download',
+	jsonb_build_object('bookKey', b."key", 'url', COALESCE(b.cover_source_url, b.cover_url),
+		'title', b.title, 'author', b.author),
+	5
+FROM public.book b
+WHERE b.cover_status = 'pending' AND b.cover_renditions IS NULL AND COALESCE(b.cover_url, '') <> ''
+	AND NOT EXISTS (SELECT 1 FROM public.job j WHERE j.kind = 'cover.download'
+		AND j.status IN ('pending', 'running') AND j.payload->>'bookKey' = b."key");
@@ -2,21 +2,37 @@ package models
 
 import "time"
 
+// States of the local copy of a cover
+const (
+	CoverPending = "pending"
+	CoverStored  = "stored"
+	CoverFailed  = "failed"
+)
+
 type Book struct {
 	ID            string    `json:"id"`
 	Title         string    `json:"title"`
 	Author        string    `json:"author"`
 	Key           string    `json:"key"`
+	Provider      string    `json:"provider,omitempty"`
+	ISBN          string    `json:"isbn"`
 	AuthorKey     string    `json:"authorKey"`
 	ReleaseYear   int       `json:"releaseYear"`
 	DateAdded     time.Time `json:"dateAdded"`
 	StartReading  time.Time `json:"startReading"`
 	FinishReading time.Time `json:"finishReading"`
-	CoverURL      string    `json:"coverURL"`
-	MyRating      float32   `json:"myRating"`
-	AVGRating     float32   `json:"avgRating"`
-	Comment       string    `json:"comment"`
-	PageCount     int       `json:"pageCount"`
-	CollecionID   string    `json:"collectionID"`
-	LocallyStored bool      `json:"locallyStored"`
+	Covers        Covers    `json:"covers"`
+	CoverStatus   string    `json:"coverStatus,omitempty"`
+	CoverPalette
+	MyRating      float32  `json:"myRating"`
+	AVGRating     float32  `json:"avgRating"`
+	RatingsCount  int      `json:"ratingsCount,omitempty"`
+	Comment       string   `json:"comment"`
+	Tags          []string `json:"tags"`
+	Moods         []string `json:"moods"`
+	PageCount     int      `json:"pageCount"`
+	CollecionID   string   `json:"collectionID"`
+	LocallyStored bool     `json:"locallyStored"`
+	Shelved       bool     `json:"shelved,omitempty"`
+	Score         float64  `json:"score,omitempty"`
 }
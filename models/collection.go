@@ -13,4 +13,70 @@ type Collection struct {
 	Exclusive      bool      `json:"exclusive"`
 	ReadCol        bool      `json:"readCol"`
 	Editable       bool      `json:"editable"`
+	//the books of a smart collection are the ones of the library that match its rules
+	Smart bool            `json:"smart"`
+	Rules *CollectionRule `json:"rules,omitempty"`
+}
+
+// Fields of the books that the rules of a smart collection can compare
+const (
+	RuleRating       = "rating"
+	RuleAVGRating    = "avgRating"
+	RulePageCount    = "pageCount"
+	RuleReleaseYear  = "releaseYear"
+	RuleStartedYear  = "startedYear"
+	RuleFinishedYear = "finishedYear"
+	RuleTitle        = "title"
+	RuleAuthor       = "author"
+	RuleTag          = "tag"
+	RuleMood         = "mood"
+	//uses the values of the shelves: read, currently-reading or to-read
+	RuleStatus = "status"
+)
+
+// Operators of the rules. For tags and moods eq and neq check if the book has the value, in checks any of them
+const (
+	OpEq       = "eq"
+	OpNeq      = "neq"
+	OpGt       = "gt"
+	OpGte      = "gte"
+	OpLt       = "lt"
+	OpLte      = "lte"
+	OpContains = "contains"
+	OpIn       = "in"
+)
+
+// How a group combines its rules
+const (
+	MatchAll = "all"
+	MatchAny = "any"
+)
+
+// A rule of a smart collection, stored as JSON. A group has match and rules, the rest compare a field:
+// {"match": "all", "rules": [{"field": "rating", "op": "gte", "value": 4}, {"field": "tag", "op": "eq", "value": "fantasía"}]}
+type CollectionRule struct {
+	Match string           `json:"match,omitempty"`
+	Rules []CollectionRule `json:"rules,omitempty"`
+	Field string           `json:"field,omitempty"`
+	Op    string           `json:"op,omitempty"`
+	Value any              `json:"value,omitempty"`
+}
+
+func (r CollectionRule) IsGroup() bool {
+	return r.Field == ""
+}
+
+// Puts a book right before or after another one of the same collection, only one of them is used
+type ReorderMove struct {
+	BookID string `json:"bookID"`
+	Before string `json:"before,omitempty"`
+	After  string `json:"after,omitempty"`
+}
+
+// The moves are applied in order. Order places the listed books at the start of the collection in the
+// given order and the rest keep their positions after them, it's applied before the moves
+type ReorderRequest struct {
+	CollectionID string        `json:"collectionID"`
+	Order        []string      `json:"order"`
+	Moves        []ReorderMove `json:"moves"`
 }
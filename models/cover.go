@@ -0,0 +1,41 @@
+package models
+
+// Names of the default renditions generated for every cover
+const (
+	RenditionThumbnail = "thumbnail"
+	RenditionList      = "list"
+	RenditionDetail    = "detail"
+	RenditionOriginal  = "original"
+)
+
+// URLs of a single size of a cover, the WebP version is only present when the server generates it
+type Rendition struct {
+	Width int    `json:"width,omitempty"`
+	JPEG  string `json:"jpeg"`
+	WebP  string `json:"webp,omitempty"`
+}
+
+type Covers map[string]Rendition
+
+// URL of the biggest rendition, used as the source when the cover is downloaded
+func (c Covers) Source() string {
+	for _, name := range []string{RenditionOriginal, RenditionDetail, RenditionList, RenditionThumbnail} {
+		if rendition, ok := c[name]; ok && rendition.JPEG != "" {
+			return rendition.JPEG
+		}
+	}
+	return ""
+}
+
+// Data to draw a placeholder while the cover loads
+type CoverPalette struct {
+	BlurHash      string `json:"blurHash"`
+	DominantColor string `json:"dominantColor"`
+	AccentColor   string `json:"accentColor"`
+}
+
+// The covers of a book with their palette, it's the result of the cover jobs and uploads
+type StoredCover struct {
+	Covers Covers `json:"covers"`
+	CoverPalette
+}
@@ -0,0 +1,8 @@
+package models
+
+// A book in one of the collections of the user, with the data of the collection it belongs to
+type ShelfEntry struct {
+	Book
+	CollectionName string `json:"collectionName"`
+	ReadCol        bool   `json:"readCol"`
+}
@@ -0,0 +1,111 @@
+package models
+
+import "time"
+
+// Exclusive shelves used by the services we import from, every entry belongs to exactly one of them
+const (
+	ShelfRead         = "read"
+	ShelfToRead       = "to-read"
+	ShelfReading      = "currently-reading"
+	ShelfDidNotFinish = "did-not-finish"
+	ShelfPaused       = "paused"
+)
+
+// Names of the collections used for the exclusive shelves that are not the read one
+const (
+	CollectionToRead       = "Por leer"
+	CollectionReading      = "Leyendo"
+	CollectionDidNotFinish = "Abandonados"
+	CollectionPaused       = "En pausa"
+)
+
+type ImportStatus string
+
+const (
+	ImportCreated ImportStatus = "created"
+	ImportSkipped ImportStatus = "skipped"
+	ImportFailed  ImportStatus = "failed"
+	//used on dry runs, the entry would be created
+	ImportPreview ImportStatus = "preview"
+)
+
+// A single row of an exported library, already converted from the format of the original service
+type ImportEntry struct {
+	Row           int       `json:"row"`
+	Title         string    `json:"title"`
+	Author        string    `json:"author"`
+	ISBNs         []string  `json:"isbns"`
+	Shelf         string    `json:"shelf"`
+	Collections   []string  `json:"collections"`
+	MyRating      float32   `json:"myRating"`
+	AVGRating     float32   `json:"avgRating"`
+	PageCount     int       `json:"pageCount"`
+	ReleaseYear   int       `json:"releaseYear"`
+	DateAdded     time.Time `json:"dateAdded"`
+	StartReading  time.Time `json:"startReading"`
+	FinishReading time.Time `json:"finishReading"`
+	Comment       string    `json:"comment"`
+	Tags          []string  `json:"tags"`
+	Moods         []string  `json:"moods"`
+}
+
+type ImportResult struct {
+	Row     int          `json:"row"`
+	Title   string       `json:"title"`
+	Status  ImportStatus `json:"status"`
+	Message string       `json:"message,omitempty"`
+	BookID  string       `json:"bookID,omitempty"`
+	BookKey string       `json:"bookKey,omitempty"`
+}
+
+type ImportReport struct {
+	DryRun         bool           `json:"dryRun"`
+	NewCollections []string       `json:"newCollections"`
+	Results        []ImportResult `json:"results"`
+}
+
+// Status of every line of a list of ISBNs
+const (
+	ISBNFound     = "found"
+	ISBNAmbiguous = "ambiguous"
+	ISBNNotFound  = "not_found"
+	ISBNInvalid   = "invalid"
+	ISBNDuplicate = "duplicate"
+	//the book was found but it's already in the library of the user
+	ISBNSkipped = "skipped"
+	//the catalog couldn't be searched or the book couldn't be stored
+	ISBNFailed = "failed"
+)
+
+// A valid line of a list of ISBNs, ISBN10 is empty for the ISBNs that start with 979
+type ISBNEntry struct {
+	Line   int    `json:"line"`
+	Input  string `json:"input"`
+	ISBN13 string `json:"isbn13"`
+	ISBN10 string `json:"isbn10,omitempty"`
+}
+
+type ISBNResult struct {
+	Line    int    `json:"line"`
+	Input   string `json:"input"`
+	ISBN13  string `json:"isbn13,omitempty"`
+	ISBN10  string `json:"isbn10,omitempty"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	BookID  string `json:"bookID,omitempty"`
+	BookKey string `json:"bookKey,omitempty"`
+	Title   string `json:"title,omitempty"`
+	//books that match an ambiguous ISBN, any of them can be added with the creation of books
+	Candidates []Book `json:"candidates,omitempty"`
+}
+
+type ISBNImportReport struct {
+	CollectionID string       `json:"collectionID"`
+	Found        int          `json:"found"`
+	Ambiguous    int          `json:"ambiguous"`
+	NotFound     int          `json:"notFound"`
+	Invalid      int          `json:"invalid"`
+	Skipped      int          `json:"skipped"`
+	Failed       int          `json:"failed"`
+	Results      []ISBNResult `json:"results"`
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	JobPending JobStatus = "pending"
+	JobRunning JobStatus = "running"
+	JobDone    JobStatus = "done"
+	//the job failed every allowed attempt and won't run again unless it is retried by an admin
+	JobDead JobStatus = "dead"
+)
+
+// Kinds of the jobs processed by the worker pool
+const (
+	JobCoverDownload = "cover.download"
+	JobImportLibrary = "import.library"
+	JobImportISBNs   = "import.isbns"
+	JobImageGC       = "images.gc"
+	JobVocabulary    = "search.vocabulary"
+)
+
+type Job struct {
+	ID          string          `json:"id"`
+	Kind        string          `json:"kind"`
+	Payload     json.RawMessage `json:"payload"`
+	Result      json.RawMessage `json:"result,omitempty"`
+	Status      JobStatus       `json:"status"`
+	OwnerID     string          `json:"ownerID,omitempty"`
+	Attempts    int             `json:"attempts"`
+	MaxAttempts int             `json:"maxAttempts"`
+	RunAt       time.Time       `json:"runAt"`
+	LastError   string          `json:"lastError,omitempty"`
+	CreatedAt   time.Time       `json:"createdAt"`
+	UpdatedAt   time.Time       `json:"updatedAt"`
+}
+
+// Without a URL, or when the URL has no image, a placeholder is generated with the title and author
+type CoverJobPayload struct {
+	BookKey string `json:"bookKey"`
+	URL     string `json:"url"`
+	Title   string `json:"title"`
+	Author  string `json:"author"`
+}
+
+type ImportJobPayload struct {
+	UserID  string        `json:"userID"`
+	Entries []ImportEntry `json:"entries"`
+}
+
+type ISBNImportJobPayload struct {
+	UserID       string      `json:"userID"`
+	CollectionID string      `json:"collectionID"`
+	Entries      []ISBNEntry `json:"entries"`
+	//lines rejected before queueing the job, copied to the report
+	Rejected []ISBNResult `json:"rejected"`
+}
+
+type ImageGCPayload struct {
+	DryRun bool `json:"dryRun"`
+}
+
+type ImageGCReport struct {
+	DryRun bool `json:"dryRun"`
+	//blobs found in the storage
+	Scanned int `json:"scanned"`
+	//blobs not used by any book or user
+	Orphans []string `json:"orphans"`
+	//orphans newer than the grace period, they may belong to an upload still in progress
+	Recent  int `json:"recent"`
+	Deleted int `json:"deleted"`
+	//URLs of the storage used by a book or user whose blob doesn't exist
+	Missing []string `json:"missing"`
+}
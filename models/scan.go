@@ -0,0 +1,18 @@
+package models
+
+// Status of a barcode read from a photo
+const (
+	ScanFound    = "found"
+	ScanNotFound = "not_found"
+	ScanNotISBN  = "not_isbn"
+	//the catalog couldn't be searched, the code can be sent again later
+	ScanFailed = "failed"
+)
+
+type ScannedBarcode struct {
+	Code   string `json:"code"`
+	Rows   int    `json:"rows"`
+	Status string `json:"status"`
+	//candidate ready to be sent to the creation of books
+	Book *Book `json:"book,omitempty"`
+}
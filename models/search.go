@@ -0,0 +1,78 @@
+package models
+
+import "strings"
+
+// Limits of the page size of the search
+const (
+	DefaultSearchLimit = 20
+	MaxSearchLimit     = 100
+)
+
+// Filters of the catalog search. Query is free text matched against everything, the other fields
+// only match their own data. A year of 0 leaves that end of the range open
+type SearchQuery struct {
+	Query    string `json:"q"`
+	Title    string `json:"title"`
+	Author   string `json:"author"`
+	ISBN     string `json:"isbn"`
+	YearFrom int    `json:"yearFrom"`
+	YearTo   int    `json:"yearTo"`
+	//ISO 639-1 code, like es or en
+	Language string `json:"language"`
+	Subject  string `json:"subject"`
+	Page     int    `json:"page"`
+	Limit    int    `json:"limit"`
+}
+
+// True when the query has something to look for, the years and the language only narrow a search
+func (q SearchQuery) HasTerms() bool {
+	return strings.TrimSpace(q.Query+q.Title+q.Author+q.ISBN+q.Subject) != ""
+}
+
+// The text used to rank the results
+func (q SearchQuery) Text() string {
+	return strings.TrimSpace(strings.Join([]string{q.Query, q.Title, q.Author}, " "))
+}
+
+func (q SearchQuery) Offset() int {
+	return (q.Page - 1) * q.Limit
+}
+
+// The years of the book are inside the range of the query
+func (q SearchQuery) MatchesYear(year int) bool {
+	if q.YearFrom > 0 && year < q.YearFrom {
+		return false
+	}
+	if q.YearTo > 0 && (year == 0 || year > q.YearTo) {
+		return false
+	}
+	return true
+}
+
+type SearchPage struct {
+	Results []Book `json:"results"`
+	Page    int    `json:"page"`
+	Limit   int    `json:"limit"`
+	//the biggest total reported by the providers, the same book can be counted by several of them
+	Total int `json:"total"`
+	//books found by each provider, like the numFound of open library
+	Providers map[string]int `json:"providers"`
+	//search term with the typos corrected using the stored books
+	DidYouMean string `json:"didYouMean,omitempty"`
+	//some provider failed, so the results can be incomplete
+	Degraded      bool           `json:"degraded"`
+	FailedSources []FailedSource `json:"failedSources,omitempty"`
+	//only the stored books were searched
+	Offline bool `json:"offline,omitempty"`
+}
+
+type FailedSource struct {
+	Provider string `json:"provider"`
+	//timeout, circuit_open, canceled or error
+	Reason string `json:"reason"`
+}
+
+type UserSearchResult struct {
+	Results    []Book `json:"results"`
+	DidYouMean string `json:"didYouMean,omitempty"`
+}
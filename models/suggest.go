@@ -0,0 +1,18 @@
+package models
+
+// Kinds of suggestion of the autocomplete
+const (
+	SuggestionBook   = "book"
+	SuggestionAuthor = "author"
+)
+
+type Suggestion struct {
+	Kind      string `json:"kind"`
+	Text      string `json:"text"`
+	ID        string `json:"id,omitempty"`
+	Key       string `json:"key,omitempty"`
+	Title     string `json:"title,omitempty"`
+	Author    string `json:"author,omitempty"`
+	Thumbnail string `json:"thumbnail,omitempty"`
+	Shelved   bool   `json:"shelved,omitempty"`
+}
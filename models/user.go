@@ -4,4 +4,5 @@ type User struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
 	IsAdmin  string `json:"isAdmin,omitempty"`
+	Avatar   Covers `json:"avatar,omitempty"`
 }
@@ -1,5 +1,6 @@
 package models
 
+// Sort of the first versions of the API, kept for the clients that still send the integer order
 type OrderOption int
 
 const (
@@ -7,4 +8,60 @@ const (
 	DateAsc
 	NameDesc
 	DateDesc
+	//uses the position chosen by the user, the books never moved go at the end by date added
+	Manual
 )
+
+// Fields used to sort the books of a collection
+const (
+	SortTitle       = "title"
+	SortAuthor      = "author"
+	SortDateAdded   = "added"
+	SortRating      = "rating"
+	SortAVGRating   = "avgRating"
+	SortStarted     = "started"
+	SortFinished    = "finished"
+	SortPageCount   = "pages"
+	SortReleaseYear = "year"
+	SortManual      = "manual"
+	//the same seed always gives the same order, so the pages don't repeat books
+	SortRandom = "random"
+)
+
+type SortKey struct {
+	Field string `json:"field"`
+	Desc  bool   `json:"desc"`
+}
+
+// Keys are applied in order, the books that tie in all of them are sorted by title
+type BookSort struct {
+	Keys []SortKey `json:"keys"`
+	Seed int64     `json:"seed,omitempty"`
+}
+
+// The newest books first
+var DefaultSort = BookSort{Keys: []SortKey{{Field: SortDateAdded, Desc: true}}}
+
+func (o OrderOption) Sort() BookSort {
+	switch o {
+	case NameAsc:
+		return BookSort{Keys: []SortKey{{Field: SortTitle}}}
+	case NameDesc:
+		return BookSort{Keys: []SortKey{{Field: SortTitle, Desc: true}}}
+	case DateAsc:
+		return BookSort{Keys: []SortKey{{Field: SortDateAdded}}}
+	case Manual:
+		return BookSort{Keys: []SortKey{{Field: SortManual}}}
+	default:
+		return DefaultSort
+	}
+}
+
+func (s BookSort) Has(field string) bool {
+	for _, key := range s.Keys {
+		if key.Field == field {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,310 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/disintegration/imaging"
+)
+
+// An EAN-13 is read as 59 runs: start guard (3), six digits (4 each), middle guard (5), six digits
+// and end guard (3). It is 95 modules wide
+const (
+	eanRuns    = 59
+	eanModules = 95
+	//photos are reduced to this width, a barcode is still several pixels per module
+	scanMaxSize = 1600
+	//distance between the rows that are read
+	scanStep = 2
+	//rows that must read the same code, a single row can be a coincidence
+	minScanRows = 2
+	//maximum difference between the widths of a digit and its pattern, in modules
+	maxDigitError = 1.6
+)
+
+// widths of the runs of the L digits, the R digits have the same widths and the G digits are reversed
+var eanDigits = [10][4]float64{
+	{3, 2, 1, 1}, {2, 2, 2, 1}, {2, 1, 2, 2}, {1, 4, 1, 1}, {1, 1, 3, 2},
+	{1, 2, 3, 1}, {1, 1, 1, 4}, {1, 3, 1, 2}, {1, 2, 1, 3}, {3, 1, 1, 2},
+}
+
+// the first digit is encoded by which of the left digits use the G patterns
+var eanFirstDigit = map[string]byte{
+	"LLLLLL": '0', "LLGLGG": '1', "LLGGLG": '2', "LLGGGL": '3', "LGLLGG": '4',
+	"LGGLLG": '5', "LGGGLL": '6', "LGLGLG": '7', "LGLGGL": '8', "LGGLGL": '9',
+}
+
+type Barcode struct {
+	Code string `json:"code"`
+	//rows of the image where the code was read, more rows means a more reliable reading
+	Rows int `json:"rows"`
+}
+
+// Finds every EAN-13 barcode of the photo. The photo is read horizontally and rotated 90 degrees, and every
+// row is read in both directions, so the barcodes can be in any of the four orientations and slightly tilted.
+// The codes are sorted by their position in the photo, from the top left
+func ScanBarcodes(data []byte) ([]Barcode, error) {
+	img, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	bounds := img.Bounds()
+	if bounds.Dx() > scanMaxSize || bounds.Dy() > scanMaxSize {
+		img = imaging.Fit(img, scanMaxSize, scanMaxSize, imaging.Box)
+	}
+	gray := imaging.Grayscale(img)
+
+	found := make(map[string]*foundCode)
+	scanImage(gray, found, false)
+	scanImage(imaging.Rotate90(gray), found, true)
+
+	codes := make([]*foundCode, 0, len(found))
+	for _, code := range found {
+		if code.rows >= minScanRows {
+			codes = append(codes, code)
+		}
+	}
+	sort.Slice(codes, func(i, j int) bool {
+		if codes[i].y != codes[j].y {
+			return codes[i].y < codes[j].y
+		}
+		if codes[i].x != codes[j].x {
+			return codes[i].x < codes[j].x
+		}
+		return codes[i].code < codes[j].code
+	})
+
+	barcodes := make([]Barcode, 0, len(codes))
+	for _, code := range codes {
+		barcodes = append(barcodes, Barcode{Code: code.code, Rows: code.rows})
+	}
+	return barcodes, nil
+}
+
+type foundCode struct {
+	code string
+	rows int
+	//first place where it was found, in the coordinates of the photo
+	x, y int
+}
+
+func scanImage(img *image.NRGBA, found map[string]*foundCode, rotated bool) {
+	width, height := img.Bounds().Dx(), img.Bounds().Dy()
+	line := make([]float64, width)
+
+	for y := 0; y < height; y += scanStep {
+		for x := 0; x < width; x++ {
+			line[x] = float64(img.Pix[y*img.Stride+x*4])
+		}
+
+		for _, match := range scanLine(line) {
+			//the rotated image has its rows on the columns of the photo
+			px, py := match.x, y
+			if rotated {
+				px, py = height-1-y, match.x
+			}
+			if code, ok := found[match.code]; ok {
+				code.rows++
+				continue
+			}
+			found[match.code] = &foundCode{code: match.code, rows: 1, x: px, y: py}
+		}
+	}
+}
+
+type lineMatch struct {
+	code string
+	x    int
+}
+
+// Splits the row in runs of bars and spaces and looks for codes in them, forwards and backwards. The edges
+// are placed where the row crosses the threshold between two pixels, so a bar of 2.5 pixels is not
+// rounded to 2 or 3
+func scanLine(line []float64) []lineMatch {
+	threshold := localThreshold(line)
+
+	runs := make([]float64, 0)
+	starts := make([]int, 0)
+	dark := line[0] < threshold[0]
+	//the runs always start with a space, so the bars are the odd ones
+	if dark {
+		runs = append(runs, 0)
+		starts = append(starts, 0)
+	}
+	edge, start := 0.0, 0
+	for x := 1; x < len(line); x++ {
+		current := line[x] < threshold[x]
+		if current == dark {
+			continue
+		}
+		before, after := line[x-1]-threshold[x-1], line[x]-threshold[x]
+		crossing := float64(x-1) + before/(before-after)
+		runs = append(runs, crossing-edge)
+		starts = append(starts, start)
+		edge, start, dark = crossing, x, current
+	}
+	runs = append(runs, float64(len(line))-edge)
+	starts = append(starts, start)
+
+	matches := make([]lineMatch, 0)
+	for i := 1; i+eanRuns <= len(runs); i += 2 {
+		if code, ok := decodeEAN(runs[i:i+eanRuns], runs[i-1]); ok {
+			matches = append(matches, lineMatch{code: code, x: starts[i]})
+		}
+	}
+
+	//backwards the bars are on odd positions if the row ended with a space, and on even positions if it
+	//ended with a bar, in that case the first bar has no margin and it's skipped
+	reversed := make([]float64, len(runs))
+	for i, run := range runs {
+		reversed[len(runs)-1-i] = run
+	}
+	first := 1
+	if len(reversed)%2 == 0 {
+		first = 2
+	}
+	for i := first; i+eanRuns <= len(reversed); i += 2 {
+		if code, ok := decodeEAN(reversed[i:i+eanRuns], reversed[i-1]); ok {
+			matches = append(matches, lineMatch{code: code, x: starts[len(runs)-1-i]})
+		}
+	}
+
+	return matches
+}
+
+// The threshold of every pixel is the average of its surroundings, so shadows and gradients of the photo
+// don't turn the spaces into bars
+func localThreshold(line []float64) []float64 {
+	//the window covers a few bars of a barcode that takes a third of the row
+	window := max(len(line)/40, 8)
+	sums := make([]float64, len(line)+1)
+	for i, value := range line {
+		sums[i+1] = sums[i] + value
+	}
+
+	threshold := make([]float64, len(line))
+	for i := range line {
+		from, to := max(i-window, 0), min(i+window+1, len(line))
+		threshold[i] = (sums[to]-sums[from])/float64(to-from) - 2
+	}
+	return threshold
+}
+
+// Decodes 59 runs that start with a bar, quiet is the space before them
+func decodeEAN(runs []float64, quiet float64) (string, bool) {
+	total := 0.0
+	for _, run := range runs {
+		total += run
+	}
+	module := total / eanModules
+	if module < 1 {
+		return "", false
+	}
+	//the barcode needs a margin of light before it
+	if quiet < module*5 {
+		return "", false
+	}
+
+	guards := [][]float64{runs[0:3], runs[27:32], runs[56:59]}
+	for _, guard := range guards {
+		for _, run := range guard {
+			if width := run / module; width < 0.4 || width > 1.8 {
+				return "", false
+			}
+		}
+	}
+
+	code := make([]byte, 13)
+	parity := make([]byte, 6)
+	for digit := 0; digit < 12; digit++ {
+		start := 3 + digit*4
+		if digit >= 6 {
+			start = 32 + (digit-6)*4
+		}
+		widths := runs[start : start+4]
+
+		value, reversed, ok := matchDigit(widths, module)
+		if !ok {
+			return "", false
+		}
+		if digit >= 6 {
+			//the right digits are never G
+			if reversed {
+				return "", false
+			}
+		} else if reversed {
+			parity[digit] = 'G'
+		} else {
+			parity[digit] = 'L'
+		}
+		code[digit+1] = '0' + byte(value)
+	}
+
+	first, ok := eanFirstDigit[string(parity)]
+	if !ok {
+		return "", false
+	}
+	code[0] = first
+
+	if !validEAN13(string(code)) {
+		return "", false
+	}
+	return string(code), true
+}
+
+// Compares the widths of a digit with every pattern, reversed is true for a G digit
+func matchDigit(widths []float64, module float64) (int, bool, bool) {
+	sum := 0.0
+	for _, width := range widths {
+		sum += width
+	}
+	//a digit is 7 modules, a big difference means these runs are not a digit
+	if sum < module*5 || sum > module*9 {
+		return 0, false, false
+	}
+	scale := 7 / sum
+
+	best, bestError, bestReversed := -1, math.MaxFloat64, false
+	for value, pattern := range eanDigits {
+		direct, reversed := 0.0, 0.0
+		for i := 0; i < 4; i++ {
+			scaled := widths[i] * scale
+			direct += math.Abs(scaled - pattern[i])
+			reversed += math.Abs(scaled - pattern[3-i])
+		}
+		if direct < bestError {
+			best, bestError, bestReversed = value, direct, false
+		}
+		if reversed < bestError {
+			best, bestError, bestReversed = value, reversed, true
+		}
+	}
+
+	if bestError > maxDigitError {
+		return 0, false, false
+	}
+	return best, bestReversed, true
+}
+
+func validEAN13(code string) bool {
+	if len(code) != 13 {
+		return false
+	}
+	sum := 0
+	for i := 0; i < 12; i++ {
+		digit := int(code[i] - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return (10-sum%10)%10 == int(code[12]-'0')
+}
+
+// The EAN-13 of the books use the prefixes of the fictional country "Bookland"
+func IsBooklandEAN(code string) bool {
+	return len(code) == 13 && (strings.HasPrefix(code, "978") || strings.HasPrefix(code, "979"))
+}
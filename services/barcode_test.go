@@ -0,0 +1,155 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"reflect"
+	"testing"
+
+	"github.com/disintegration/imaging"
+)
+
+// Modules of an EAN-13, true for the bars. The check digit is not verified, so broken codes can be drawn
+func eanBars(code string) []bool {
+	bars := make([]bool, 0, eanModules)
+	add := func(widths []float64, bar bool) {
+		for _, width := range widths {
+			for i := 0; i < int(width); i++ {
+				bars = append(bars, bar)
+			}
+			bar = !bar
+		}
+	}
+
+	parity := ""
+	for pattern, first := range eanFirstDigit {
+		if first == code[0] {
+			parity = pattern
+		}
+	}
+
+	add([]float64{1, 1, 1}, true)
+	for i := 1; i <= 6; i++ {
+		widths := eanDigits[code[i]-'0']
+		if parity[i-1] == 'G' {
+			widths = [4]float64{widths[3], widths[2], widths[1], widths[0]}
+		}
+		add(widths[:], false)
+	}
+	add([]float64{1, 1, 1, 1, 1}, false)
+	for i := 7; i <= 12; i++ {
+		widths := eanDigits[code[i]-'0']
+		add(widths[:], true)
+	}
+	add([]float64{1, 1, 1}, true)
+	return bars
+}
+
+// Draws the barcodes one below the other with a margin of 12 modules, module is the width in pixels
+func drawBarcodes(module int, codes ...string) image.Image {
+	const margin, height = 12, 40
+	img := image.NewGray(image.Rect(0, 0, (eanModules+2*margin)*module, len(codes)*(height+margin)+margin))
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	for i, code := range codes {
+		top := margin + i*(height+margin)
+		for x, bar := range eanBars(code) {
+			if !bar {
+				continue
+			}
+			for px := (margin + x) * module; px < (margin+x+1)*module; px++ {
+				for py := top; py < top+height; py++ {
+					img.SetGray(px, py, color.Gray{})
+				}
+			}
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	buff := bytes.NewBuffer(nil)
+	if err := png.Encode(buff, img); err != nil {
+		t.Fatal(err)
+	}
+	return buff.Bytes()
+}
+
+func TestEANBars(t *testing.T) {
+	if bars := eanBars("9780306406157"); len(bars) != eanModules {
+		t.Fatalf("eanBars() has %d modules, want %d", len(bars), eanModules)
+	}
+}
+
+func TestScanBarcodesOrientations(t *testing.T) {
+	codes := []string{"9780306406157", "9791234567896", "4006381333931"}
+	orientations := []struct {
+		name   string
+		rotate func(image.Image) *image.NRGBA
+	}{
+		{"0", imaging.Clone},
+		{"90", imaging.Rotate90},
+		{"180", imaging.Rotate180},
+		{"270", imaging.Rotate270},
+	}
+
+	for _, code := range codes {
+		for _, orientation := range orientations {
+			t.Run(code+"/"+orientation.name, func(t *testing.T) {
+				data := encodePNG(t, orientation.rotate(drawBarcodes(3, code)))
+				barcodes, err := ScanBarcodes(data)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(barcodes) != 1 || barcodes[0].Code != code {
+					t.Fatalf("ScanBarcodes() = %+v, want %s", barcodes, code)
+				}
+				if barcodes[0].Rows < minScanRows {
+					t.Errorf("the code was read in %d rows", barcodes[0].Rows)
+				}
+			})
+		}
+	}
+}
+
+func TestScanBarcodes(t *testing.T) {
+	tests := []struct {
+		name   string
+		module int
+		codes  []string
+		want   []string
+	}{
+		{"thin bars", 2, []string{"9780306406157"}, []string{"9780306406157"}},
+		{"wide bars", 5, []string{"9780804429573"}, []string{"9780804429573"}},
+		{"several codes from the top", 3, []string{"9791234567896", "9780306406157"}, []string{"9791234567896", "9780306406157"}},
+		{"wrong check digit", 3, []string{"9780306406158"}, []string{}},
+		{"a good code next to a broken one", 3, []string{"9780306406150", "9780804429573"}, []string{"9780804429573"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			barcodes, err := ScanBarcodes(encodePNG(t, drawBarcodes(test.module, test.codes...)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(barcodes))
+			for _, barcode := range barcodes {
+				got = append(got, barcode.Code)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ScanBarcodes() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestScanBarcodesInvalidImage(t *testing.T) {
+	if _, err := ScanBarcodes([]byte("not an image")); err == nil {
+		t.Error("ScanBarcodes() of a text didn't fail")
+	}
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+var ErrCircuitOpen = errors.New("circuit open")
+
+// States of a circuit breaker
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+const (
+	defaultBreakerFailures = 5
+	defaultBreakerCooldown = 30 * time.Second
+)
+
+// Stops calling a provider after Failures errors in a row. Once the cooldown passes a single call is let
+// through, if it works the provider is used again and if it fails the cooldown starts again
+type CircuitBreaker struct {
+	mu       sync.Mutex
+	failures int
+	cooldown time.Duration
+
+	state       string
+	consecutive int
+	openedAt    time.Time
+	probing     bool
+}
+
+func NewCircuitBreaker(failures int, cooldown time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{failures: max(failures, 1), cooldown: cooldown, state: BreakerClosed}
+}
+
+// Reads CATALOG_BREAKER_FAILURES and CATALOG_BREAKER_COOLDOWN
+func NewCircuitBreakerFromEnv() *CircuitBreaker {
+	failures := defaultBreakerFailures
+	if value, err := strconv.Atoi(os.Getenv("CATALOG_BREAKER_FAILURES")); err == nil && value > 0 {
+		failures = value
+	}
+	cooldown := defaultBreakerCooldown
+	if value, err := time.ParseDuration(os.Getenv("CATALOG_BREAKER_COOLDOWN")); err == nil && value > 0 {
+		cooldown = value
+	}
+	return NewCircuitBreaker(failures, cooldown)
+}
+
+// Returns ErrCircuitOpen when the provider must not be called. Every call allowed must be followed by Record
+func (b *CircuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		//only the probe goes through until it finishes
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	//the search was abandoned by the client, it says nothing about the provider
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+	if err == nil {
+		b.state = BreakerClosed
+		b.consecutive = 0
+		return
+	}
+
+	b.consecutive++
+	if b.state == BreakerHalfOpen || b.consecutive >= b.failures {
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+type breakerProvider struct {
+	provider CatalogProvider
+	breaker  *CircuitBreaker
+}
+
+// Calls the provider only while the breaker allows it
+func WithBreaker(provider CatalogProvider, breaker *CircuitBreaker) CatalogProvider {
+	return &breakerProvider{provider: provider, breaker: breaker}
+}
+
+func (p *breakerProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *breakerProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	if err := p.breaker.Allow(); err != nil {
+		return nil, err
+	}
+	//a panic is recorded as a failure, otherwise a probe of the half-open state would never finish
+	panicked := true
+	defer func() {
+		if panicked {
+			recovered := recover()
+			p.breaker.Record(fmt.Errorf("provider %s panicked: %v", p.provider.Name(), recovered))
+			panic(recovered)
+		}
+	}()
+
+	page, err := p.provider.Search(ctx, query)
+	panicked = false
+	p.breaker.Record(err)
+	return page, err
+}
+
+// Reasons of the failed sources of a search
+const (
+	FailureTimeout     = "timeout"
+	FailureCircuitOpen = "circuit_open"
+	FailureCanceled    = "canceled"
+	FailureError       = "error"
+)
+
+func FailureReason(err error) string {
+	switch {
+	case errors.Is(err, ErrCircuitOpen):
+		return FailureCircuitOpen
+	case errors.Is(err, context.DeadlineExceeded):
+		return FailureTimeout
+	case errors.Is(err, context.Canceled):
+		return FailureCanceled
+	default:
+		return FailureError
+	}
+}
@@ -0,0 +1,110 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	failure := errors.New("provider down")
+
+	tests := []struct {
+		name     string
+		failures int
+		cooldown time.Duration
+		//results of the calls recorded in order
+		calls     []error
+		wantState string
+		wantAllow error
+	}{
+		{"starts closed", 2, time.Hour, nil, BreakerClosed, nil},
+		{"below the limit", 2, time.Hour, []error{failure}, BreakerClosed, nil},
+		{"opens at the limit", 2, time.Hour, []error{failure, failure}, BreakerOpen, ErrCircuitOpen},
+		{"success resets the count", 2, time.Hour, []error{failure, nil, failure}, BreakerClosed, nil},
+		{"cancellations don't count", 2, time.Hour, []error{failure, context.Canceled, context.Canceled}, BreakerClosed, nil},
+		{"deadlines count", 2, time.Hour, []error{context.DeadlineExceeded, fmt.Errorf("wrapped: %w", context.DeadlineExceeded)}, BreakerOpen, ErrCircuitOpen},
+		{"at least one failure", 0, time.Hour, []error{failure}, BreakerOpen, ErrCircuitOpen},
+		{"probe after the cooldown", 1, 0, []error{failure}, BreakerHalfOpen, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := NewCircuitBreaker(test.failures, test.cooldown)
+			for _, err := range test.calls {
+				if allowed := breaker.Allow(); allowed != nil {
+					t.Fatalf("Allow() = %v before the limit", allowed)
+				}
+				breaker.Record(err)
+			}
+
+			if err := breaker.Allow(); !errors.Is(err, test.wantAllow) {
+				t.Errorf("Allow() = %v, want %v", err, test.wantAllow)
+			}
+			if state := breaker.State(); state != test.wantState {
+				t.Errorf("State() = %s, want %s", state, test.wantState)
+			}
+		})
+	}
+}
+
+func TestCircuitBreakerHalfOpen(t *testing.T) {
+	tests := []struct {
+		name      string
+		probe     error
+		wantState string
+	}{
+		{"probe works", nil, BreakerClosed},
+		{"probe fails", errors.New("still down"), BreakerOpen},
+		{"probe canceled", context.Canceled, BreakerHalfOpen},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			breaker := NewCircuitBreaker(3, 0)
+			for i := 0; i < 3; i++ {
+				breaker.Allow()
+				breaker.Record(errors.New("down"))
+			}
+
+			if err := breaker.Allow(); err != nil {
+				t.Fatalf("Allow() of the probe = %v", err)
+			}
+			//only the probe goes through while it runs
+			if err := breaker.Allow(); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("Allow() during the probe = %v, want ErrCircuitOpen", err)
+			}
+			breaker.Record(test.probe)
+
+			if state := breaker.State(); state != test.wantState {
+				t.Errorf("State() = %s, want %s", state, test.wantState)
+			}
+		})
+	}
+}
+
+func TestBreakerProviderPanic(t *testing.T) {
+	breaker := NewCircuitBreaker(1, 0)
+	provider := WithBreaker(panicProvider{}, breaker)
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != "broken provider" {
+				t.Errorf("recover() = %v, want the panic of the provider", recovered)
+			}
+		}()
+		provider.Search(context.Background(), models.SearchQuery{})
+	}()
+
+	if state := breaker.State(); state != BreakerOpen {
+		t.Errorf("State() = %s, want %s", state, BreakerOpen)
+	}
+	//the cooldown is over, so the probe that panicked didn't leave the breaker stuck
+	if err := breaker.Allow(); err != nil {
+		t.Errorf("Allow() = %v after the panic", err)
+	}
+}
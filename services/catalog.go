@@ -0,0 +1,299 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+// Names of the providers used in CATALOG_PROVIDERS
+const (
+	ProviderLocal       = "local"
+	ProviderOpenLibrary = "openlibrary"
+	ProviderGoogleBooks = "googlebooks"
+	ProviderWikidata    = "wikidata"
+	ProviderFake        = "fake"
+)
+
+const (
+	defaultProviders       = "local,openlibrary"
+	defaultProviderTimeout = 5 * time.Second
+	defaultSearchDeadline  = 8 * time.Second
+)
+
+// A source of books for the search. The providers must stop when the context is done and return the
+// page of the query. The filters a provider can't send to its service are applied to the books it receives
+type CatalogProvider interface {
+	Name() string
+	Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error)
+}
+
+// A page of the results of a provider, Total counts every result and not only the ones of the page
+type CatalogPage struct {
+	Books []models.Book
+	Total int
+}
+
+// Every remote provider shares the client and its connections, the deadline of each request comes
+// from its context
+var catalogClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		MaxIdleConns:          64,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   5 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		ForceAttemptHTTP2:     true,
+	},
+}
+
+type providerEntry struct {
+	provider CatalogProvider
+	timeout  time.Duration
+	breaker  *CircuitBreaker
+}
+
+// With OFFLINE_MODE=true nothing is requested to external services, the catalog only uses the stored books
+// and the books without a local cover get a placeholder
+func OfflineMode() bool {
+	return os.Getenv("OFFLINE_MODE") == "true"
+}
+
+// Runs the search on all its providers at the same time
+type Catalog struct {
+	providers []providerEntry
+	//limit of the whole search, the context of the request can end it before
+	deadline time.Duration
+	offline  bool
+}
+
+func NewCatalog() *Catalog {
+	return &Catalog{}
+}
+
+// Adds a provider, with a timeout of 0 the provider only stops when the context of the search is done.
+// The breaker is the one used by the provider, if it has one, and it's only kept to report its state
+func (c *Catalog) Add(provider CatalogProvider, timeout time.Duration, breaker *CircuitBreaker) {
+	c.providers = append(c.providers, providerEntry{provider: provider, timeout: timeout, breaker: breaker})
+}
+
+// True when the remote providers were left out because of OFFLINE_MODE
+func (c *Catalog) Offline() bool {
+	return c.offline
+}
+
+type ProviderStatus struct {
+	Name    string `json:"name"`
+	Timeout string `json:"timeout"`
+	Breaker string `json:"breaker,omitempty"`
+}
+
+func (c *Catalog) Status() []ProviderStatus {
+	status := make([]ProviderStatus, 0, len(c.providers))
+	for _, entry := range c.providers {
+		current := ProviderStatus{Name: entry.provider.Name(), Timeout: entry.timeout.String()}
+		if entry.breaker != nil {
+			current.Breaker = entry.breaker.State()
+		}
+		status = append(status, current)
+	}
+	return status
+}
+
+func (c *Catalog) Providers() []string {
+	names := make([]string, 0, len(c.providers))
+	for _, entry := range c.providers {
+		names = append(names, entry.provider.Name())
+	}
+	return names
+}
+
+// Builds the catalog with the providers listed in CATALOG_PROVIDERS, in that order. The local provider
+// depends on the database, so it's received instead of being created here. The timeout of every provider
+// is CATALOG_TIMEOUT and can be changed for one of them with CATALOG_TIMEOUT_<NAME> (e.g.
+// CATALOG_TIMEOUT_WIKIDATA=10s), CATALOG_DEADLINE limits the whole search. Every remote provider has its
+// own circuit breaker and, with a cache, its responses go through it. The cache is outside the breaker so
+// the old responses are still used while the circuit is open. In offline mode the remote providers are skipped
+func NewCatalogFromEnv(local CatalogProvider, cache *ResponseCache) (*Catalog, error) {
+	names := os.Getenv("CATALOG_PROVIDERS")
+	if strings.TrimSpace(names) == "" {
+		names = defaultProviders
+	}
+
+	defaultTimeout := defaultProviderTimeout
+	if value := os.Getenv("CATALOG_TIMEOUT"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CATALOG_TIMEOUT: %w", err)
+		}
+		defaultTimeout = parsed
+	}
+
+	catalog := NewCatalog()
+	catalog.deadline = defaultSearchDeadline
+	if value := os.Getenv("CATALOG_DEADLINE"); value != "" {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CATALOG_DEADLINE: %w", err)
+		}
+		catalog.deadline = parsed
+	}
+	catalog.offline = OfflineMode()
+
+	for _, name := range splitList(names) {
+		name = strings.ToLower(name)
+		remote := name != ProviderLocal && name != ProviderFake
+		if remote && catalog.offline {
+			continue
+		}
+
+		var provider CatalogProvider
+		switch name {
+		case ProviderLocal:
+			if local == nil {
+				return nil, errors.New("the local provider is not available")
+			}
+			provider = local
+		case ProviderOpenLibrary:
+			provider = NewOpenLibraryProvider()
+		case ProviderGoogleBooks:
+			provider = NewGoogleBooksProvider(os.Getenv("GOOGLE_BOOKS_API_KEY"))
+		case ProviderWikidata:
+			provider = NewWikidataProvider()
+		case ProviderFake:
+			fake, err := NewFakeProviderFromFile(os.Getenv("CATALOG_FAKE_BOOKS"))
+			if err != nil {
+				return nil, err
+			}
+			provider = fake
+		default:
+			return nil, fmt.Errorf("unknown catalog provider %q", name)
+		}
+
+		var breaker *CircuitBreaker
+		if remote {
+			breaker = NewCircuitBreakerFromEnv()
+			provider = WithBreaker(provider, breaker)
+			if cache != nil {
+				provider = cache.Wrap(provider)
+			}
+		}
+
+		timeout := defaultTimeout
+		if value := os.Getenv("CATALOG_TIMEOUT_" + strings.ToUpper(name)); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid timeout for %s: %w", name, err)
+			}
+			timeout = parsed
+		}
+
+		catalog.Add(provider, timeout, breaker)
+	}
+
+	return catalog, nil
+}
+
+// The books found by one provider, Err is set when the provider failed or ran out of time
+type ProviderResult struct {
+	Provider string
+	Books    []models.Book
+	Total    int
+	Err      error
+	Duration time.Duration
+}
+
+// Searches on every provider and waits for all of them. The results keep the order of the providers
+// no matter which one finished first
+func (c *Catalog) Search(ctx context.Context, query models.SearchQuery) []ProviderResult {
+	results := make([]ProviderResult, len(c.providers))
+	if c.deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.deadline)
+		defer cancel()
+	}
+
+	var wg sync.WaitGroup
+	for i, entry := range c.providers {
+		wg.Add(1)
+		go func(i int, entry providerEntry) {
+			defer wg.Done()
+			results[i] = runProvider(ctx, entry, query)
+		}(i, entry)
+	}
+	wg.Wait()
+
+	return results
+}
+
+func runProvider(ctx context.Context, entry providerEntry, query models.SearchQuery) (result ProviderResult) {
+	result.Provider = entry.provider.Name()
+	start := time.Now()
+	defer func() {
+		result.Duration = time.Since(start)
+		//a broken provider can't take the whole search down
+		if r := recover(); r != nil {
+			result.Books = nil
+			result.Err = fmt.Errorf("provider %s panicked: %v", result.Provider, r)
+		}
+	}()
+
+	if entry.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, entry.timeout)
+		defer cancel()
+	}
+
+	page, err := entry.provider.Search(ctx, query)
+	if err != nil {
+		return ProviderResult{Provider: result.Provider, Err: err}
+	}
+	for i := range page.Books {
+		if page.Books[i].Provider == "" {
+			page.Books[i].Provider = result.Provider
+		}
+	}
+	result.Books = page.Books
+	result.Total = page.Total
+	return result
+}
+
+// Checks a book against the filters of the query. The text filters match when they are contained in the
+// field, ignoring case and accents. The language and subject are unknown for a single book, so they are
+// left to the providers
+func MatchesQuery(book models.Book, query models.SearchQuery) bool {
+	title, author := searchText(book.Title), searchText(book.Author)
+	if text := searchText(query.Query); text != "" && !strings.Contains(title+" "+author, text) {
+		return false
+	}
+	if text := searchText(query.Title); text != "" && !strings.Contains(title, text) {
+		return false
+	}
+	if text := searchText(query.Author); text != "" && !strings.Contains(author, text) {
+		return false
+	}
+	if query.ISBN != "" && book.ISBN != query.ISBN {
+		return false
+	}
+	return query.MatchesYear(book.ReleaseYear)
+}
+
+// Cuts the page of the query from every book found
+func PageOf(books []models.Book, query models.SearchQuery) *CatalogPage {
+	page := &CatalogPage{Books: make([]models.Book, 0), Total: len(books)}
+	start := query.Offset()
+	if start >= len(books) {
+		return page
+	}
+	end := min(start+query.Limit, len(books))
+	page.Books = append(page.Books, books[start:end]...)
+	return page
+}
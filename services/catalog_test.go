@@ -0,0 +1,164 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+type panicProvider struct{}
+
+func (panicProvider) Name() string {
+	return "panic"
+}
+
+func (panicProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	panic("broken provider")
+}
+
+func TestCatalogSearch(t *testing.T) {
+	books := []models.Book{
+		{Key: "OL1", Title: "Dune", Author: "Frank Herbert"},
+		{Key: "OL2", Title: "Dune Messiah", Author: "Frank Herbert"},
+		{Key: "OL3", Title: "Emma", Author: "Jane Austen"},
+	}
+	query := models.SearchQuery{Query: "dune", Page: 1, Limit: 10}
+
+	type entry struct {
+		provider CatalogProvider
+		timeout  time.Duration
+	}
+	type want struct {
+		provider string
+		keys     []string
+		reason   string
+	}
+
+	tests := []struct {
+		name     string
+		deadline time.Duration
+		entries  []entry
+		want     []want
+	}{
+		{
+			name: "order of the providers, not of the answers",
+			entries: []entry{
+				{&FakeProvider{ProviderName: "slow", Books: books, Delay: 50 * time.Millisecond}, 0},
+				{&FakeProvider{ProviderName: "fast", Books: books[1:]}, 0},
+			},
+			want: []want{
+				{provider: "slow", keys: []string{"OL1", "OL2"}},
+				{provider: "fast", keys: []string{"OL2"}},
+			},
+		},
+		{
+			name: "timeout of a provider",
+			entries: []entry{
+				{&FakeProvider{ProviderName: "stuck", Books: books, Delay: time.Minute}, 20 * time.Millisecond},
+				{&FakeProvider{ProviderName: "fast", Books: books}, 20 * time.Millisecond},
+			},
+			want: []want{
+				{provider: "stuck", reason: FailureTimeout},
+				{provider: "fast", keys: []string{"OL1", "OL2"}},
+			},
+		},
+		{
+			name:     "deadline of the search",
+			deadline: 20 * time.Millisecond,
+			entries: []entry{
+				{&FakeProvider{ProviderName: "fast", Books: books}, 0},
+				{&FakeProvider{ProviderName: "stuck", Books: books, Delay: time.Minute}, 0},
+			},
+			want: []want{
+				{provider: "fast", keys: []string{"OL1", "OL2"}},
+				{provider: "stuck", reason: FailureTimeout},
+			},
+		},
+		{
+			name: "panic of a provider",
+			entries: []entry{
+				{panicProvider{}, 0},
+				{&FakeProvider{ProviderName: "fast", Books: books}, 0},
+			},
+			want: []want{
+				{provider: "panic", reason: FailureError},
+				{provider: "fast", keys: []string{"OL1", "OL2"}},
+			},
+		},
+		{
+			name: "failed provider",
+			entries: []entry{
+				{&FakeProvider{ProviderName: "broken", Err: errors.New("bad gateway")}, 0},
+			},
+			want: []want{
+				{provider: "broken", reason: FailureError},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			catalog := NewCatalog()
+			catalog.deadline = test.deadline
+			for _, entry := range test.entries {
+				catalog.Add(entry.provider, entry.timeout, nil)
+			}
+
+			start := time.Now()
+			results := catalog.Search(context.Background(), query)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Search() took %s, the slow providers were not stopped", elapsed)
+			}
+
+			if len(results) != len(test.want) {
+				t.Fatalf("Search() returned %d results, want %d", len(results), len(test.want))
+			}
+			for i, result := range results {
+				want := test.want[i]
+				if result.Provider != want.provider {
+					t.Errorf("result %d is of %s, want %s", i, result.Provider, want.provider)
+				}
+				if want.reason != "" {
+					if result.Err == nil || FailureReason(result.Err) != want.reason {
+						t.Errorf("%s failed with %v, want %s", result.Provider, result.Err, want.reason)
+					}
+					if len(result.Books) != 0 {
+						t.Errorf("%s failed but returned %d books", result.Provider, len(result.Books))
+					}
+					continue
+				}
+				if result.Err != nil {
+					t.Errorf("%s failed with %v", result.Provider, result.Err)
+				}
+				keys := make([]string, 0, len(result.Books))
+				for _, book := range result.Books {
+					keys = append(keys, book.Key)
+					if book.Provider != result.Provider {
+						t.Errorf("book %s has provider %q, want %q", book.Key, book.Provider, result.Provider)
+					}
+				}
+				if !reflect.DeepEqual(keys, want.keys) {
+					t.Errorf("%s returned %v, want %v", result.Provider, keys, want.keys)
+				}
+				if result.Total != len(want.keys) {
+					t.Errorf("%s total = %d, want %d", result.Provider, result.Total, len(want.keys))
+				}
+			}
+		})
+	}
+}
+
+func TestCatalogSearchPanicMessage(t *testing.T) {
+	catalog := NewCatalog()
+	catalog.Add(panicProvider{}, 0, nil)
+
+	results := catalog.Search(context.Background(), models.SearchQuery{Query: "dune", Page: 1, Limit: 10})
+	if len(results) != 1 || results[0].Err == nil || !strings.Contains(results[0].Err.Error(), "broken provider") {
+		t.Errorf("Search() = %+v, want the panic as the error", results)
+	}
+}
@@ -0,0 +1,251 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+// Writes the shelf entries of a library in one of the supported formats. Close must be called after the
+// last entry since some formats keep data until the end
+type Exporter interface {
+	Write(entry *models.ShelfEntry) error
+	Close() error
+	ContentType() string
+	Extension() string
+}
+
+func NewExporter(format string, w io.Writer) (Exporter, error) {
+	switch format {
+	case "csv", "":
+		return &csvExporter{writer: csv.NewWriter(w)}, nil
+	case "json":
+		return &jsonExporter{w: w, encoder: json.NewEncoder(w)}, nil
+	case "goodreads":
+		return &goodreadsExporter{writer: csv.NewWriter(w)}, nil
+	default:
+		return nil, fmt.Errorf("unknown export format %q", format)
+	}
+}
+
+type csvExporter struct {
+	writer        *csv.Writer
+	headerWritten bool
+}
+
+func (e *csvExporter) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	return e.writer.Write([]string{"Title", "Author", "Key", "Author Key", "ISBN", "Release Year", "Page Count",
+		"Collection", "Date Added", "Start Reading", "Finish Reading", "My Rating", "Average Rating",
+		"Comment", "Tags", "Moods"})
+}
+
+func (e *csvExporter) Write(entry *models.ShelfEntry) error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	return e.writer.Write([]string{entry.Title, entry.Author, entry.Key, entry.AuthorKey, entry.ISBN,
+		formatInt(entry.ReleaseYear), formatInt(entry.PageCount), entry.CollectionName,
+		formatDate(time.DateOnly, entry.DateAdded), formatDate(time.DateOnly, entry.StartReading),
+		formatDate(time.DateOnly, entry.FinishReading), formatRating(entry.MyRating), formatRating(entry.AVGRating),
+		entry.Comment, strings.Join(entry.Tags, ", "), strings.Join(entry.Moods, ", ")})
+}
+
+// an empty library still gets the header
+func (e *csvExporter) Close() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *csvExporter) ContentType() string { return "text/csv; charset=utf-8" }
+func (e *csvExporter) Extension() string   { return "csv" }
+
+// writes a JSON array one element at a time
+type jsonExporter struct {
+	w       io.Writer
+	encoder *json.Encoder
+	count   int
+}
+
+func (e *jsonExporter) Write(entry *models.ShelfEntry) error {
+	separator := ","
+	if e.count == 0 {
+		separator = "["
+	}
+	e.count++
+	if _, err := io.WriteString(e.w, separator); err != nil {
+		return err
+	}
+	return e.encoder.Encode(entry)
+}
+
+func (e *jsonExporter) Close() error {
+	closing := "]"
+	if e.count == 0 {
+		closing = "[]"
+	}
+	_, err := io.WriteString(e.w, closing)
+	return err
+}
+
+func (e *jsonExporter) ContentType() string { return "application/json; charset=utf-8" }
+func (e *jsonExporter) Extension() string   { return "json" }
+
+// Goodreads expects a single row per book, so the entries of the same book are merged in its shelves.
+// The entries must arrive grouped by book, as ExportUserLibrary sends them
+type goodreadsExporter struct {
+	writer        *csv.Writer
+	headerWritten bool
+	current       *models.ShelfEntry
+	shelves       []string
+	exclusive     string
+}
+
+func (e *goodreadsExporter) Write(entry *models.ShelfEntry) error {
+	if e.current != nil && e.current.ID != entry.ID {
+		if err := e.writeCurrent(); err != nil {
+			return err
+		}
+	}
+	if e.current == nil {
+		copied := *entry
+		e.current = &copied
+		e.exclusive = ""
+		e.shelves = e.shelves[:0]
+	}
+
+	shelf := goodreadsShelf(entry)
+	if !isDefaultShelf(shelf) {
+		e.shelves = append(e.shelves, shelf)
+		return nil
+	}
+	//the entry of the most advanced shelf keeps the rating and dates of the book
+	if shelfPriority(shelf) > shelfPriority(e.exclusive) {
+		copied := *entry
+		e.current = &copied
+		e.exclusive = shelf
+	}
+
+	return nil
+}
+
+func (e *goodreadsExporter) writeHeader() error {
+	if e.headerWritten {
+		return nil
+	}
+	e.headerWritten = true
+	return e.writer.Write([]string{"Title", "Author", "ISBN", "ISBN13", "My Rating", "Average Rating",
+		"Number of Pages", "Original Publication Year", "Date Read", "Date Added", "Bookshelves",
+		"Exclusive Shelf", "My Review"})
+}
+
+func (e *goodreadsExporter) writeCurrent() error {
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+
+	entry := e.current
+	isbn, isbn13 := "", ""
+	if len(entry.ISBN) == 13 {
+		isbn13 = entry.ISBN
+	} else {
+		isbn = entry.ISBN
+	}
+
+	//books that are only in custom collections go to the default shelf of goodreads
+	if e.exclusive == "" {
+		e.exclusive = models.ShelfToRead
+	}
+	shelves := append([]string{e.exclusive}, e.shelves...)
+	err := e.writer.Write([]string{entry.Title, entry.Author, goodreadsISBN(isbn), goodreadsISBN(isbn13),
+		strconv.Itoa(int(math.Round(float64(entry.MyRating)))), formatRating(entry.AVGRating),
+		formatInt(entry.PageCount), formatInt(entry.ReleaseYear), formatDate(goodreadsDateLayout, entry.FinishReading),
+		formatDate(goodreadsDateLayout, entry.DateAdded), strings.Join(shelves, ", "), e.exclusive, entry.Comment})
+	e.current = nil
+	return err
+}
+
+func (e *goodreadsExporter) Close() error {
+	if e.current != nil {
+		if err := e.writeCurrent(); err != nil {
+			return err
+		}
+	}
+	if err := e.writeHeader(); err != nil {
+		return err
+	}
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+func (e *goodreadsExporter) ContentType() string { return "text/csv; charset=utf-8" }
+func (e *goodreadsExporter) Extension() string   { return "csv" }
+
+// translates a collection to the shelves of goodreads, the opposite of the mapping done by the imports
+func goodreadsShelf(entry *models.ShelfEntry) string {
+	switch {
+	case entry.ReadCol || !entry.FinishReading.IsZero():
+		return models.ShelfRead
+	case entry.CollectionName == models.CollectionToRead:
+		return models.ShelfToRead
+	case entry.CollectionName == models.CollectionReading:
+		return models.ShelfReading
+	default:
+		return strings.ToLower(strings.ReplaceAll(entry.CollectionName, " ", "-"))
+	}
+}
+
+func shelfPriority(shelf string) int {
+	switch shelf {
+	case models.ShelfRead:
+		return 3
+	case models.ShelfReading:
+		return 2
+	case models.ShelfToRead:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func goodreadsISBN(isbn string) string {
+	if isbn == "" {
+		return ""
+	}
+	return fmt.Sprintf(`="%s"`, isbn)
+}
+
+func formatDate(layout string, date time.Time) string {
+	if date.IsZero() {
+		return ""
+	}
+	return date.Format(layout)
+}
+
+func formatInt(value int) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.Itoa(value)
+}
+
+func formatRating(value float32) string {
+	if value == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(float64(value), 'f', -1, 32)
+}
@@ -0,0 +1,68 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+// Provider with a fixed list of books, it's used to work without network and to simulate slow or broken
+// sources. The filters are applied with MatchesQuery
+type FakeProvider struct {
+	ProviderName string
+	Books        []models.Book
+	//time it takes to answer, the context is respected while waiting
+	Delay time.Duration
+	//when set every search fails with it
+	Err error
+}
+
+// Reads the books from a JSON file with an array of books, without file the provider has no books
+func NewFakeProviderFromFile(path string) (*FakeProvider, error) {
+	provider := &FakeProvider{ProviderName: ProviderFake}
+	if path == "" {
+		return provider, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &provider.Books); err != nil {
+		return nil, err
+	}
+	return provider, nil
+}
+
+func (p *FakeProvider) Name() string {
+	if p.ProviderName == "" {
+		return ProviderFake
+	}
+	return p.ProviderName
+}
+
+func (p *FakeProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	if p.Delay > 0 {
+		timer := time.NewTimer(p.Delay)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if p.Err != nil {
+		return nil, p.Err
+	}
+
+	books := make([]models.Book, 0)
+	for _, book := range p.Books {
+		if MatchesQuery(book, query) {
+			books = append(books, book)
+		}
+	}
+	return PageOf(books, query), nil
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"os"
+	"strconv"
+)
+
+const (
+	defaultSimilarityThreshold = 0.4
+	defaultFuzzyMinResults     = 3
+)
+
+// The trigram search runs when the full text search finds less than MinResults books. Threshold is the
+// minimum word similarity (from 0 to 1) of a match, the lower it is the more typos are tolerated
+type FuzzyConfig struct {
+	Threshold  float64
+	MinResults int
+}
+
+// Reads SEARCH_SIMILARITY_THRESHOLD and SEARCH_FUZZY_MIN_RESULTS, a minimum of 0 disables the fallback
+func LoadFuzzyConfig() FuzzyConfig {
+	config := FuzzyConfig{Threshold: defaultSimilarityThreshold, MinResults: defaultFuzzyMinResults}
+
+	if threshold, err := strconv.ParseFloat(os.Getenv("SEARCH_SIMILARITY_THRESHOLD"), 64); err == nil && threshold > 0 && threshold <= 1 {
+		config.Threshold = threshold
+	}
+	if minResults, err := strconv.Atoi(os.Getenv("SEARCH_FUZZY_MIN_RESULTS")); err == nil && minResults >= 0 {
+		config.MinResults = minResults
+	}
+
+	return config
+}
@@ -0,0 +1,143 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+const goodreadsDateLayout = "2006/01/02"
+
+// Reads the CSV generated by the Goodreads export tool (My Books > Import and export)
+func ParseGoodreadsCSV(r io.Reader) ([]models.ImportEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := indexColumns(header)
+	for _, required := range []string{"title", "exclusive shelf"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %q, the file is not a goodreads export", required)
+		}
+	}
+
+	entries := make([]models.ImportEntry, 0)
+	row := 1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		row++
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		get := func(name string) string {
+			return column(record, columns, name)
+		}
+
+		entry := models.ImportEntry{
+			Row:     row,
+			Title:   get("title"),
+			Author:  get("author"),
+			ISBNs:   cleanISBNs(get("isbn13"), get("isbn")),
+			Shelf:   get("exclusive shelf"),
+			Comment: get("my review"),
+		}
+
+		entry.MyRating = parseFloat(get("my rating"))
+		entry.AVGRating = parseFloat(get("average rating"))
+		entry.PageCount = parseInt(get("number of pages"))
+		entry.ReleaseYear = parseInt(get("original publication year"))
+		if entry.ReleaseYear == 0 {
+			entry.ReleaseYear = parseInt(get("year published"))
+		}
+		entry.DateAdded = parseDate(goodreadsDateLayout, get("date added"))
+		entry.FinishReading = parseDate(goodreadsDateLayout, get("date read"))
+		//goodreads allows to mark a book as read without a date, the app uses the date to know if a book was read
+		if entry.Shelf == models.ShelfRead && entry.FinishReading.IsZero() {
+			entry.FinishReading = entry.DateAdded
+		}
+
+		//the bookshelves column repeats the exclusive shelf, everything else is a custom shelf
+		for _, shelf := range strings.Split(get("bookshelves"), ",") {
+			shelf = strings.TrimSpace(shelf)
+			if shelf == "" || shelf == entry.Shelf || isDefaultShelf(shelf) {
+				continue
+			}
+			entry.Collections = append(entry.Collections, shelf)
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+func isDefaultShelf(shelf string) bool {
+	return shelf == models.ShelfRead || shelf == models.ShelfToRead || shelf == models.ShelfReading
+}
+
+func indexColumns(header []string) map[string]int {
+	columns := make(map[string]int, len(header))
+	for i, name := range header {
+		name = strings.TrimPrefix(name, "\ufeff")
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	return columns
+}
+
+func column(record []string, columns map[string]int, name string) string {
+	i, ok := columns[name]
+	if !ok || i >= len(record) {
+		return ""
+	}
+	return strings.TrimSpace(record[i])
+}
+
+// goodreads writes the ISBNs as excel formulas (="0345391802") so they keep the leading zeros
+func cleanISBNs(values ...string) []string {
+	isbns := make([]string, 0, len(values))
+	for _, v := range values {
+		v = strings.Trim(v, `="`)
+		v = strings.ReplaceAll(v, "-", "")
+		if v != "" {
+			isbns = append(isbns, v)
+		}
+	}
+	return isbns
+}
+
+func parseFloat(value string) float32 {
+	result, err := strconv.ParseFloat(value, 32)
+	if err != nil {
+		return 0
+	}
+	return float32(result)
+}
+
+func parseInt(value string) int {
+	result, err := strconv.Atoi(value)
+	if err != nil {
+		return 0
+	}
+	return result
+}
+
+func parseDate(layout, value string) time.Time {
+	result, err := time.Parse(layout, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return result
+}
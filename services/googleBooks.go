@@ -0,0 +1,182 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+const defaultGoogleBooksURL = "https://www.googleapis.com/books/v1/volumes"
+
+type googleBooksResponse struct {
+	TotalItems int                 `json:"totalItems"`
+	Items      []googleBooksVolume `json:"items"`
+}
+
+type googleBooksVolume struct {
+	ID         string `json:"id"`
+	VolumeInfo struct {
+		Title               string   `json:"title"`
+		Subtitle            string   `json:"subtitle"`
+		Authors             []string `json:"authors"`
+		PublishedDate       string   `json:"publishedDate"`
+		PageCount           int      `json:"pageCount"`
+		AverageRating       float32  `json:"averageRating"`
+		RatingsCount        int      `json:"ratingsCount"`
+		IndustryIdentifiers []struct {
+			Type       string `json:"type"`
+			Identifier string `json:"identifier"`
+		} `json:"industryIdentifiers"`
+		ImageLinks struct {
+			SmallThumbnail string `json:"smallThumbnail"`
+			Thumbnail      string `json:"thumbnail"`
+		} `json:"imageLinks"`
+	} `json:"volumeInfo"`
+}
+
+// The keys of the books of google are prefixed so they can't collide with the ones of open library
+const googleBooksKeyPrefix = "gbooks:"
+
+type GoogleBooksProvider struct {
+	searchURL string
+	apiKey    string
+}
+
+// The API works without a key but with a lower quota. GOOGLE_BOOKS_URL changes the endpoint
+func NewGoogleBooksProvider(apiKey string) *GoogleBooksProvider {
+	searchURL := os.Getenv("GOOGLE_BOOKS_URL")
+	if searchURL == "" {
+		searchURL = defaultGoogleBooksURL
+	}
+	return &GoogleBooksProvider{searchURL: searchURL, apiKey: apiKey}
+}
+
+func (p *GoogleBooksProvider) Name() string {
+	return ProviderGoogleBooks
+}
+
+// google has no filter for the years, they are applied to the page received so the page can be shorter
+// than the limit
+func (p *GoogleBooksProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	terms := make([]string, 0, 5)
+	if query.Query != "" {
+		terms = append(terms, query.Query)
+	}
+	if query.Title != "" {
+		terms = append(terms, "intitle:"+query.Title)
+	}
+	if query.Author != "" {
+		terms = append(terms, "inauthor:"+query.Author)
+	}
+	if query.ISBN != "" {
+		terms = append(terms, "isbn:"+query.ISBN)
+	}
+	if query.Subject != "" {
+		terms = append(terms, "subject:"+query.Subject)
+	}
+
+	params := url.Values{}
+	params.Set("q", strings.Join(terms, " "))
+	params.Set("startIndex", strconv.Itoa(query.Offset()))
+	//google doesn't send more than 40 results at once
+	params.Set("maxResults", strconv.Itoa(min(query.Limit, 40)))
+	params.Set("printType", "books")
+	if query.Language != "" {
+		params.Set("langRestrict", strings.ToLower(query.Language))
+	}
+	if p.apiKey != "" {
+		params.Set("key", p.apiKey)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.searchURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := catalogClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("google books responded with status %d", resp.StatusCode)
+	}
+
+	var response googleBooksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	page := &CatalogPage{Books: make([]models.Book, 0, len(response.Items)), Total: response.TotalItems}
+	for _, volume := range response.Items {
+		if volume.ID == "" || volume.VolumeInfo.Title == "" {
+			continue
+		}
+		book := volumeToBook(volume)
+		if !query.MatchesYear(book.ReleaseYear) {
+			continue
+		}
+		page.Books = append(page.Books, book)
+	}
+	return page, nil
+}
+
+func volumeToBook(volume googleBooksVolume) models.Book {
+	info := volume.VolumeInfo
+
+	title := info.Title
+	if info.Subtitle != "" {
+		title = fmt.Sprint(title, ": ", info.Subtitle)
+	}
+
+	author := "Unknown"
+	if len(info.Authors) > 0 {
+		author = strings.Join(info.Authors, ", ")
+	}
+
+	book := models.Book{
+		Title:        title,
+		Author:       author,
+		Key:          googleBooksKeyPrefix + volume.ID,
+		AVGRating:    info.AverageRating,
+		RatingsCount: info.RatingsCount,
+		PageCount:    info.PageCount,
+		Covers:       models.Covers{},
+	}
+
+	//the date can be just the year
+	if len(info.PublishedDate) >= 4 {
+		if year, err := strconv.Atoi(info.PublishedDate[:4]); err == nil {
+			book.ReleaseYear = year
+		}
+	}
+
+	for _, identifier := range info.IndustryIdentifiers {
+		if identifier.Type == "ISBN_13" || (identifier.Type == "ISBN_10" && book.ISBN == "") {
+			book.ISBN = identifier.Identifier
+		}
+	}
+
+	//google sends the links with http
+	if info.ImageLinks.SmallThumbnail != "" {
+		book.Covers[models.RenditionThumbnail] = models.Rendition{JPEG: secureURL(info.ImageLinks.SmallThumbnail)}
+	}
+	if info.ImageLinks.Thumbnail != "" {
+		book.Covers[models.RenditionList] = models.Rendition{JPEG: secureURL(info.ImageLinks.Thumbnail)}
+	}
+
+	return book
+}
+
+func secureURL(link string) string {
+	if strings.HasPrefix(link, "http://") {
+		return "https://" + strings.TrimPrefix(link, "http://")
+	}
+	return link
+}
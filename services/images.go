@@ -1,72 +1,129 @@
 package services
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
-	"image"
-	"image/jpeg"
+	_ "image/jpeg"
+	_ "image/png"
 	"io"
 	"net/http"
 	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
-	"github.com/disintegration/imaging"
+	"github.com/TheSgtPepper23/GreenLibrary/models"
 )
 
-func ResizeImage(data []byte) ([]byte, error) {
-	imageData, _, err := image.Decode(bytes.NewReader(data))
+const (
+	defaultMaxImageBytes = 5 << 20
+	imageTimeout         = time.Second * 20
+)
+
+// Errors that won't be solved by downloading the image again
+var (
+	ErrImageNotFound = errors.New("image not found")
+	ErrNotAnImage    = errors.New("the response is not an image")
+	ErrImageTooLarge = errors.New("image exceeds the size limit")
+)
+
+var imageClient = &http.Client{Timeout: imageTimeout}
+
+// the limit can be changed with COVER_MAX_BYTES
+func maxImageBytes() int64 {
+	limit, err := strconv.ParseInt(os.Getenv("COVER_MAX_BYTES"), 10, 64)
+	if err != nil || limit <= 0 {
+		return defaultMaxImageBytes
+	}
+	return limit
+}
+
+// Downloads an image validating the status, the content type and the size of the response
+func DownloadImage(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	resizedImage := imaging.Resize(imageData, 180, 0, imaging.Lanczos)
-	buff := bytes.NewBuffer(nil)
-	err = jpeg.Encode(buff, resizedImage, nil)
-	return buff.Bytes(), nil
-}
+	req.Header.Add("User-Agent", "bluefive.xyz:greenLibrary:andresdglez@gmail.com")
+
+	resp, err := imageClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, ErrImageNotFound
+	case resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests:
+		//the server may recover, so these can be retried
+		return nil, fmt.Errorf("image server responded %d", resp.StatusCode)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%w: status %d", ErrImageNotFound, resp.StatusCode)
+	}
 
-// Downloads the image from the service, resize it, and store it in this server, then returns updates the data with said URL
-func ProcessImage(url, bookKey string, done chan (bool), updateFunction func(string)) {
-	result := <-done
+	if contentType := resp.Header.Get("Content-Type"); contentType != "" && !strings.HasPrefix(contentType, "image/") {
+		return nil, fmt.Errorf("%w: %s", ErrNotAnImage, contentType)
+	}
 
-	if !result {
-		return
+	limit := maxImageBytes()
+	if resp.ContentLength > limit {
+		return nil, ErrImageTooLarge
 	}
-	resp, err := http.Get(url)
+
+	//reads one byte over the limit to know if the body was truncated
+	imgBytes, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
 	if err != nil {
-		PrintRedError(err.Error())
-		return
+		return nil, err
+	}
+	if int64(len(imgBytes)) > limit {
+		return nil, ErrImageTooLarge
+	}
+
+	//the header can't be trusted, some servers send HTML error pages as images
+	if sniffed := http.DetectContentType(imgBytes); !strings.HasPrefix(sniffed, "image/") {
+		return nil, fmt.Errorf("%w: %s", ErrNotAnImage, sniffed)
+	}
+
+	return imgBytes, nil
+}
+
+// Downloads the image from the service, generates its renditions and saves them in the store,
+// then returns the URLs where they can be found and the palette of the cover
+func ProcessImage(ctx context.Context, store BlobStore, url string) (models.Covers, models.CoverPalette, error) {
+	imgBytes, err := DownloadImage(ctx, url)
+	if err != nil {
+		return nil, models.CoverPalette{}, err
 	}
-	defer resp.Body.Close()
 
-	//Gets the image bytes from the URL
-	imgBytes, err := io.ReadAll(resp.Body)
+	rendered, err := RenderCover(imgBytes, LoadRenditionConfig())
 	if err != nil {
-		PrintRedError(err.Error())
-		return
+		return nil, models.CoverPalette{}, fmt.Errorf("%w: %s", ErrNotAnImage, err.Error())
 	}
 
-	fileName := fmt.Sprint(bookKey, ".jpg")
-	imgUrl := filepath.Join(os.Getenv("IMG_DIR"), fileName)
+	covers, err := StoreRenditions(ctx, store, CoverPrefix, rendered.Renditions)
+	return covers, rendered.Palette, err
+}
 
-	//Creates the directory in case it doesnt exists
-	err = os.MkdirAll(filepath.Dir(imgUrl), 0777)
+// Generates the placeholder of a book without cover and stores its renditions like any other cover
+func ProcessPlaceholder(ctx context.Context, store BlobStore, title, author, bookKey string) (models.Covers, models.CoverPalette, error) {
+	placeholder, err := RenderPlaceholder(title, author, bookKey)
 	if err != nil {
-		PrintRedError(err.Error())
-		return
+		return nil, models.CoverPalette{}, err
 	}
 
-	// resizedImg, err := ResizeImage(imgBytes)
-	// if err != nil {
-	// 	PrintRedError(err.Error())
-	// 	return
-	// }
-	//writes the image to the hard drive
-	err = os.WriteFile(imgUrl, imgBytes, 0644)
+	rendered, err := RenderCover(placeholder, LoadRenditionConfig())
 	if err != nil {
-		PrintRedError(err.Error())
-		return
+		return nil, models.CoverPalette{}, err
 	}
-	finalURL := fmt.Sprint(os.Getenv("IMG_URL"), fileName)
-	updateFunction(finalURL)
-	return
+
+	covers, err := StoreRenditions(ctx, store, CoverPrefix, rendered.Renditions)
+	return covers, rendered.Palette, err
+}
+
+// True for the errors that won't change if the image is downloaded again
+func IsPermanentImageError(err error) bool {
+	return errors.Is(err, ErrImageNotFound) || errors.Is(err, ErrNotAnImage) || errors.Is(err, ErrImageTooLarge) ||
+		errors.Is(err, ErrTooManyPixels) || errors.Is(err, ErrUnsupportedImage)
 }
@@ -0,0 +1,167 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
+	_ "golang.org/x/image/webp"
+	"golang.org/x/sync/singleflight"
+)
+
+const defaultImageWidths = "64,120,180,240,400"
+
+var (
+	ErrInvalidKey   = errors.New("invalid image key")
+	ErrInvalidWidth = errors.New("width not allowed")
+)
+
+var contentKeyName = regexp.MustCompile(`^([0-9a-f]{64})\.[a-z]+$`)
+
+// Rejects the keys that could escape the storage, like the ones with ".." segments
+func ValidKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the hash of a key generated by ContentKey, or an empty string if the key is not content addressed.
+// The content behind these keys never changes, so they can be cached forever
+func ContentHash(key string) string {
+	match := contentKeyName.FindStringSubmatch(path.Base(key))
+	if match == nil || path.Base(path.Dir(key)) != match[1][:2] {
+		return ""
+	}
+	return match[1]
+}
+
+// Generates resized versions of the stored images on request and keeps them on disk, only for the widths in
+// IMAGE_WIDTHS so the cache can't be filled with arbitrary sizes
+type ResizeCache struct {
+	dir    string
+	widths map[int]bool
+	//avoids resizing the same image twice when many requests arrive at once
+	group singleflight.Group
+}
+
+// The cache lives in IMAGE_CACHE_DIR, or in the temporary directory of the system if it is not set
+func NewResizeCacheFromEnv() *ResizeCache {
+	widths := os.Getenv("IMAGE_WIDTHS")
+	if widths == "" {
+		widths = defaultImageWidths
+	}
+	return NewResizeCache(resizeCacheDir(), widths)
+}
+
+func resizeCacheDir() string {
+	if dir := os.Getenv("IMAGE_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "greenlibrary-images")
+}
+
+func NewResizeCache(dir, widths string) *ResizeCache {
+	cache := &ResizeCache{dir: dir, widths: make(map[int]bool)}
+	for _, width := range splitList(widths) {
+		if parsed, err := strconv.Atoi(width); err == nil && parsed > 0 {
+			cache.widths[parsed] = true
+		}
+	}
+	return cache
+}
+
+// Returns the image of the store resized to the width, keeping the format of the original
+func (c *ResizeCache) Resized(ctx context.Context, store BlobStore, key string, width int) ([]byte, error) {
+	if !c.widths[width] {
+		return nil, ErrInvalidWidth
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprint(key, "@", width)))
+	cachePath := filepath.Join(c.dir, hex.EncodeToString(sum[:])+path.Ext(key))
+
+	//the requests that arrive while the image is being resized wait for it and share the result. The context
+	//of the first one is not used, so its cancellation doesn't fail the others
+	data, err, _ := c.group.Do(cachePath, func() (any, error) {
+		return c.resize(context.WithoutCancel(ctx), store, key, width, cachePath)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data.([]byte), nil
+}
+
+func (c *ResizeCache) resize(ctx context.Context, store BlobStore, key string, width int, cachePath string) ([]byte, error) {
+	if data, err := os.ReadFile(cachePath); err == nil {
+		return data, nil
+	}
+
+	original, err := store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := resizeEncoded(original, width, path.Ext(key))
+	if err != nil {
+		return nil, err
+	}
+
+	//a failure of the cache doesn't prevent answering with the resized image. Every write uses its own
+	//temporary file, so a reader never sees a half written image even if another process resizes it too
+	if err := os.MkdirAll(c.dir, 0777); err == nil {
+		if tmp, err := os.CreateTemp(c.dir, ".resize-*"); err == nil {
+			_, err := tmp.Write(data)
+			if closeErr := tmp.Close(); err == nil && closeErr == nil {
+				os.Chmod(tmp.Name(), 0644)
+				os.Rename(tmp.Name(), cachePath)
+			}
+			os.Remove(tmp.Name())
+		}
+	}
+
+	return data, nil
+}
+
+func resizeEncoded(data []byte, width int, extension string) ([]byte, error) {
+	source, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if source.Bounds().Dx() <= width {
+		return data, nil
+	}
+	resized := imaging.Resize(source, width, 0, imaging.Lanczos)
+
+	config := LoadRenditionConfig()
+	buff := bytes.NewBuffer(nil)
+	switch strings.ToLower(extension) {
+	case ".webp":
+		err = webp.Encode(buff, resized, webp.Options{Quality: config.WebPQuality})
+	case ".png":
+		err = png.Encode(buff, resized)
+	default:
+		err = jpeg.Encode(buff, resized, &jpeg.Options{Quality: config.JPEGQuality})
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Store that counts the reads and takes a while to answer, so the requests overlap
+type slowStore struct {
+	BlobStore
+	gets atomic.Int32
+}
+
+func (s *slowStore) Get(ctx context.Context, key string) ([]byte, error) {
+	s.gets.Add(1)
+	time.Sleep(50 * time.Millisecond)
+	return s.BlobStore.Get(ctx, key)
+}
+
+func TestResizeCacheConcurrent(t *testing.T) {
+	source := image.NewNRGBA(image.Rect(0, 0, 400, 200))
+	for x := 0; x < 400; x++ {
+		for y := 0; y < 200; y++ {
+			source.Set(x, y, color.NRGBA{uint8(x), uint8(y), 120, 255})
+		}
+	}
+	buff := bytes.NewBuffer(nil)
+	if err := png.Encode(buff, source); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	store := &slowStore{BlobStore: NewLocalStore(t.TempDir(), "")}
+	key := ContentKey(CoverPrefix, buff.Bytes(), "png")
+	if err := store.Put(ctx, key, buff.Bytes(), "image/png"); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	cache := NewResizeCache(dir, "120")
+
+	const requests = 20
+	results := make([][]byte, requests)
+	errs := make([]error, requests)
+	var wg sync.WaitGroup
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			//half of the requests are abandoned, they must not fail the rest
+			requestCtx, cancel := context.WithCancel(ctx)
+			if i%2 == 0 {
+				cancel()
+			}
+			defer cancel()
+			results[i], errs[i] = cache.Resized(requestCtx, store, key, 120)
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range results {
+		if errs[i] != nil {
+			t.Fatalf("request %d failed: %v", i, errs[i])
+		}
+		if !bytes.Equal(results[i], results[0]) {
+			t.Fatalf("request %d got a different image", i)
+		}
+	}
+	if gets := store.gets.Load(); gets != 1 {
+		t.Errorf("the original was read %d times, want 1", gets)
+	}
+
+	resized, err := png.Decode(bytes.NewReader(results[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if width := resized.Bounds().Dx(); width != 120 {
+		t.Errorf("width = %d, want 120", width)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		names := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			names = append(names, entry.Name())
+		}
+		t.Fatalf("cache files = %v, want only the resized image", names)
+	}
+	cached, err := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, results[0]) {
+		t.Error("the cached file is not the resized image")
+	}
+
+	//a later request is served from the disk
+	if _, err := cache.Resized(ctx, store, key, 120); err != nil {
+		t.Fatal(err)
+	}
+	if gets := store.gets.Load(); gets != 1 {
+		t.Errorf("the original was read %d times after the cache was filled, want 1", gets)
+	}
+	if _, err := cache.Resized(ctx, store, key, 121); err != ErrInvalidWidth {
+		t.Errorf("Resized() with a width out of the list = %v, want ErrInvalidWidth", err)
+	}
+}
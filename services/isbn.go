@@ -0,0 +1,166 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+var (
+	ErrInvalidISBN  = errors.New("an ISBN has 10 or 13 digits")
+	ErrISBNChecksum = errors.New("the check digit of the ISBN is wrong")
+	ErrNotBookland  = errors.New("an ISBN-13 starts with 978 or 979")
+	ErrTooManyISBNs = errors.New("the list has too many ISBNs")
+)
+
+// Messages shown to the user in the report of an import
+var isbnMessages = map[error]string{
+	ErrInvalidISBN:  "El ISBN debe tener 10 o 13 dígitos",
+	ErrISBNChecksum: "El dígito verificador del ISBN no es correcto",
+	ErrNotBookland:  "El ISBN debe comenzar con 978 o 979",
+}
+
+// Limit of ISBNs of a single list. They are resolved ISBNImportWorkers at a time and an ISBN can take two
+// searches of up to the deadline of the catalog (8s), so the whole list fits in the 10 minutes of a job
+const (
+	MaxISBNList       = 250
+	ISBNImportWorkers = 8
+)
+
+// Validates an ISBN-10 or ISBN-13 and returns it as an ISBN-13. Spaces and hyphens are ignored
+func NormalizeISBN(input string) (string, error) {
+	isbn := cleanISBN(input)
+
+	switch len(isbn) {
+	case 10:
+		if !validISBN10(isbn) {
+			return "", ErrISBNChecksum
+		}
+		return ISBN10To13(isbn), nil
+	case 13:
+		if strings.ContainsRune(isbn, 'X') {
+			return "", ErrInvalidISBN
+		}
+		if !IsBooklandEAN(isbn) {
+			return "", ErrNotBookland
+		}
+		if !validEAN13(isbn) {
+			return "", ErrISBNChecksum
+		}
+		return isbn, nil
+	default:
+		return "", ErrInvalidISBN
+	}
+}
+
+// The digits of the ISBN with the check digit X in uppercase, anything else is dropped. An X out of the
+// last place makes the ISBN invalid, so it's kept to fail the validation
+func cleanISBN(input string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if (r >= '0' && r <= '9') || r == 'X' {
+			sb.WriteRune(r)
+		}
+	}
+	return sb.String()
+}
+
+func validISBN10(isbn string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		var digit int
+		switch {
+		case isbn[i] == 'X' && i == 9:
+			digit = 10
+		case isbn[i] >= '0' && isbn[i] <= '9':
+			digit = int(isbn[i] - '0')
+		default:
+			return false
+		}
+		sum += digit * (10 - i)
+	}
+	return sum%11 == 0
+}
+
+// Expects a valid ISBN-10
+func ISBN10To13(isbn string) string {
+	code := []byte("978" + isbn[:9] + "0")
+	code[12] = eanCheckDigit(string(code[:12]))
+	return string(code)
+}
+
+// Only the ISBN-13 that start with 978 have an ISBN-10, for the rest it returns an empty string
+func ISBN13To10(isbn string) string {
+	if len(isbn) != 13 || !strings.HasPrefix(isbn, "978") {
+		return ""
+	}
+	body := isbn[3:12]
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(body[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return body + "X"
+	}
+	return body + string(rune('0'+check))
+}
+
+func eanCheckDigit(code string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		digit := int(code[i] - '0')
+		if i%2 == 1 {
+			digit *= 3
+		}
+		sum += digit
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+// Reads one ISBN per line, the empty lines and the ones that start with # are ignored. The valid ISBNs are
+// returned in the order of the list without repetitions, the invalid and repeated lines are returned as results
+func ParseISBNList(r io.Reader) ([]models.ISBNEntry, []models.ISBNResult, error) {
+	entries := make([]models.ISBNEntry, 0)
+	rejected := make([]models.ISBNResult, 0)
+	seen := make(map[string]int)
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" || strings.HasPrefix(input, "#") {
+			continue
+		}
+		//some scanner apps export "isbn,date" or "isbn;title"
+		if cut := strings.IndexAny(input, ",;\t"); cut >= 0 {
+			input = strings.TrimSpace(input[:cut])
+		}
+
+		isbn, err := NormalizeISBN(input)
+		if err != nil {
+			rejected = append(rejected, models.ISBNResult{Line: line, Input: input, Status: models.ISBNInvalid, Message: isbnMessages[err]})
+			continue
+		}
+		if first, ok := seen[isbn]; ok {
+			rejected = append(rejected, models.ISBNResult{
+				Line: line, Input: input, ISBN13: isbn, ISBN10: ISBN13To10(isbn),
+				Status: models.ISBNDuplicate, Message: "El ISBN ya aparece en la línea " + strconv.Itoa(first),
+			})
+			continue
+		}
+		seen[isbn] = line
+
+		if len(entries) == MaxISBNList {
+			return nil, nil, ErrTooManyISBNs
+		}
+		entries = append(entries, models.ISBNEntry{Line: line, Input: input, ISBN13: isbn, ISBN10: ISBN13To10(isbn)})
+	}
+
+	return entries, rejected, scanner.Err()
+}
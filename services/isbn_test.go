@@ -0,0 +1,104 @@
+package services
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeISBN(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		err   error
+	}{
+		{"9780306406157", "9780306406157", nil},
+		{"978-0-306-40615-7", "9780306406157", nil},
+		{" 978 0306 406157 ", "9780306406157", nil},
+		{"0306406152", "9780306406157", nil},
+		{"0-306-40615-2", "9780306406157", nil},
+		{"080442957X", "9780804429573", nil},
+		{"080442957x", "9780804429573", nil},
+		{"9791234567896", "9791234567896", nil},
+		{"0306406153", "", ErrISBNChecksum},
+		{"9780306406158", "", ErrISBNChecksum},
+		{"08044X9573", "", ErrISBNChecksum},
+		{"978030640615X", "", ErrInvalidISBN},
+		{"1234567890128", "", ErrNotBookland},
+		{"4006381333931", "", ErrNotBookland},
+		{"", "", ErrInvalidISBN},
+		{"abc", "", ErrInvalidISBN},
+		{"030640615", "", ErrInvalidISBN},
+		{"97803064061570", "", ErrInvalidISBN},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			got, err := NormalizeISBN(test.input)
+			if !errors.Is(err, test.err) || (test.err == nil && err != nil) {
+				t.Fatalf("NormalizeISBN(%q) error = %v, want %v", test.input, err, test.err)
+			}
+			if got != test.want {
+				t.Errorf("NormalizeISBN(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
+
+func TestISBNConversions(t *testing.T) {
+	tests := []struct {
+		isbn10 string
+		isbn13 string
+	}{
+		{"0306406152", "9780306406157"},
+		{"080442957X", "9780804429573"},
+		{"0140449132", "9780140449136"},
+		{"8420412147", "9788420412146"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.isbn10, func(t *testing.T) {
+			if got := ISBN10To13(test.isbn10); got != test.isbn13 {
+				t.Errorf("ISBN10To13(%q) = %q, want %q", test.isbn10, got, test.isbn13)
+			}
+			if got := ISBN13To10(test.isbn13); got != test.isbn10 {
+				t.Errorf("ISBN13To10(%q) = %q, want %q", test.isbn13, got, test.isbn10)
+			}
+		})
+	}
+}
+
+func TestISBN13To10WithoutISBN10(t *testing.T) {
+	for _, isbn := range []string{"9791234567896", "4006381333931", "978030640615", ""} {
+		if got := ISBN13To10(isbn); got != "" {
+			t.Errorf("ISBN13To10(%q) = %q, want empty", isbn, got)
+		}
+	}
+}
+
+func TestParseISBNListErrors(t *testing.T) {
+	var full strings.Builder
+	for i := 0; i <= MaxISBNList; i++ {
+		fmt.Fprintln(&full, ISBN10To13(fmt.Sprintf("%09dX", i)))
+	}
+
+	tests := []struct {
+		name string
+		list string
+		err  error
+	}{
+		{"too many ISBNs", full.String(), ErrTooManyISBNs},
+		{"line too long", strings.Repeat("9", bufio.MaxScanTokenSize+1), bufio.ErrTooLong},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := ParseISBNList(strings.NewReader(test.list))
+			if !errors.Is(err, test.err) {
+				t.Errorf("ParseISBNList() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
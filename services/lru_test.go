@@ -0,0 +1,89 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLRU(t *testing.T) {
+	type op struct {
+		put   bool
+		key   string
+		value int
+	}
+
+	tests := []struct {
+		name     string
+		capacity int
+		ops      []op
+		present  map[string]int
+		missing  []string
+	}{
+		{
+			name:     "drops the oldest",
+			capacity: 2,
+			ops:      []op{{true, "a", 1}, {true, "b", 2}, {true, "c", 3}},
+			present:  map[string]int{"b": 2, "c": 3},
+			missing:  []string{"a"},
+		},
+		{
+			name:     "get refreshes",
+			capacity: 2,
+			ops:      []op{{true, "a", 1}, {true, "b", 2}, {false, "a", 0}, {true, "c", 3}},
+			present:  map[string]int{"a": 1, "c": 3},
+			missing:  []string{"b"},
+		},
+		{
+			name:     "put replaces and refreshes",
+			capacity: 2,
+			ops:      []op{{true, "a", 1}, {true, "b", 2}, {true, "a", 10}, {true, "c", 3}},
+			present:  map[string]int{"a": 10, "c": 3},
+			missing:  []string{"b"},
+		},
+		{
+			name:     "missing get doesn't change the order",
+			capacity: 2,
+			ops:      []op{{true, "a", 1}, {true, "b", 2}, {false, "z", 0}, {true, "c", 3}},
+			present:  map[string]int{"b": 2, "c": 3},
+			missing:  []string{"a", "z"},
+		},
+		{
+			name:     "capacity of at least one",
+			capacity: 0,
+			ops:      []op{{true, "a", 1}, {true, "b", 2}},
+			present:  map[string]int{"b": 2},
+			missing:  []string{"a"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lru := NewLRU[int](test.capacity)
+			for _, op := range test.ops {
+				if op.put {
+					lru.Put(op.key, op.value)
+				} else {
+					lru.Get(op.key)
+				}
+			}
+
+			got := make(map[string]int)
+			for key := range test.present {
+				if value, ok := lru.Get(key); ok {
+					got[key] = value
+				}
+			}
+			if !reflect.DeepEqual(got, test.present) {
+				t.Errorf("entries = %v, want %v", got, test.present)
+			}
+			for _, key := range test.missing {
+				if value, ok := lru.Get(key); ok {
+					t.Errorf("Get(%q) = %v, want missing", key, value)
+				}
+			}
+			if lru.Len() != len(test.present) {
+				t.Errorf("Len() = %d, want %d", lru.Len(), len(test.present))
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package services
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
-	"sync"
 	"time"
 	"unicode"
 
@@ -14,12 +18,21 @@ import (
 	"golang.org/x/text/unicode/norm"
 )
 
+const defaultSearchURL = "https://openlibrary.org/search.json"
+
+var (
+	ErrBookNotFound = errors.New("book not found")
+	ErrOffline      = errors.New("the external catalogs are disabled by OFFLINE_MODE")
+)
+
 type response struct {
 	NumFound int   `json:"numFound"`
 	Docs     []doc `json:"docs"`
 }
 
 type doc struct {
+	Key             string   `json:"key"`
+	EditionKey      []string `json:"edition_key"`
 	AuthorKey       []string `json:"author_key"`
 	AuthorName      []string `json:"author_name"`
 	CoverEditinoKey string   `json:"cover_edition_key"`
@@ -27,76 +40,256 @@ type doc struct {
 	NumberOfPages   int      `json:"number_of_pages_median"`
 	Title           string   `json:"title"`
 	AvgRating       float32  `json:"ratings_average"`
+	RatingsCount    int      `json:"ratings_count"`
+	ISBN            []string `json:"isbn"`
 }
 
-func SearchBook(bookTitle string, target *[]models.Book, wg *sync.WaitGroup, mu *sync.Mutex, errChan chan (error)) {
-	defer wg.Done()
-	start := time.Now()
-	bookTitle = normalizeString(bookTitle)
-	bookTitle = strings.ReplaceAll(bookTitle, " ", "+")
+type OpenLibraryProvider struct {
+	searchURL string
+	imageURL  string
+}
 
-	req, err := http.NewRequest("GET", os.Getenv("OPEN_LIBRARY_URL")+bookTitle, nil)
+// The search uses OPEN_LIBRARY_SEARCH_URL and the covers IMAGE_URL
+func NewOpenLibraryProvider() *OpenLibraryProvider {
+	return &OpenLibraryProvider{
+		searchURL: openLibrarySearchURL(),
+		imageURL:  os.Getenv("IMAGE_URL"),
+	}
+}
+
+func (p *OpenLibraryProvider) Name() string {
+	return ProviderOpenLibrary
+}
+
+// Every filter is sent to open library, so the page and numFound of the response are used as they are
+func (p *OpenLibraryProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	response, err := fetchDocs(ctx, p.searchURL+"?"+openLibraryParams(query).Encode())
 	if err != nil {
-		errChan <- err
-		return
+		return nil, err
 	}
 
-	req.Header.Add("User-Agent", "bluefive.xyz:greenLibrary:andresdglez@gmail.com")
+	page := &CatalogPage{Books: make([]models.Book, 0, len(response.Docs)), Total: response.NumFound}
+	for i := 0; i < len(response.Docs); i++ {
+		if bookKey(response.Docs[i]) == "" {
+			continue
+		}
+		book := docToBook(response.Docs[i], p.imageURL)
+		//the docs list every ISBN of the work, only the searched one identifies the edition
+		if query.ISBN != "" && slices.Contains(response.Docs[i].ISBN, query.ISBN) {
+			book.ISBN = query.ISBN
+		}
+		page.Books = append(page.Books, book)
+	}
 
-	client := &http.Client{}
+	return page, nil
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		errChan <- err
-		return
+// open library uses the MARC codes for the languages
+var marcLanguages = map[string]string{
+	"es": "spa", "en": "eng", "fr": "fre", "de": "ger", "it": "ita", "pt": "por",
+	"ca": "cat", "ja": "jpn", "ru": "rus", "zh": "chi", "nl": "dut", "la": "lat",
+}
+
+func openLibraryParams(query models.SearchQuery) url.Values {
+	params := url.Values{}
+
+	terms := make([]string, 0, 2)
+	if query.Query != "" {
+		terms = append(terms, normalizeString(query.Query))
+	}
+	if query.YearFrom > 0 || query.YearTo > 0 {
+		from, to := "*", "*"
+		if query.YearFrom > 0 {
+			from = strconv.Itoa(query.YearFrom)
+		}
+		if query.YearTo > 0 {
+			to = strconv.Itoa(query.YearTo)
+		}
+		terms = append(terms, fmt.Sprintf("first_publish_year:[%s TO %s]", from, to))
+	}
+	if len(terms) > 0 {
+		params.Set("q", strings.Join(terms, " "))
 	}
 
-	defer resp.Body.Close()
+	if query.Title != "" {
+		params.Set("title", normalizeString(query.Title))
+	}
+	if query.Author != "" {
+		params.Set("author", normalizeString(query.Author))
+	}
+	if query.ISBN != "" {
+		params.Set("isbn", query.ISBN)
+	}
+	if query.Subject != "" {
+		params.Set("subject", query.Subject)
+	}
+	if query.Language != "" {
+		language := strings.ToLower(query.Language)
+		if marc, ok := marcLanguages[language]; ok {
+			language = marc
+		}
+		params.Set("language", language)
+	}
 
-	var response response
-	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
-		errChan <- err
-		return
+	params.Set("page", strconv.Itoa(query.Page))
+	params.Set("limit", strconv.Itoa(query.Limit))
+	return params
+}
+
+func openLibrarySearchURL() string {
+	searchURL := os.Getenv("OPEN_LIBRARY_SEARCH_URL")
+	if searchURL == "" {
+		searchURL = defaultSearchURL
+	}
+	return searchURL
+}
+
+// Looks for a single book using the data available in an import. The ISBNs are tried first since they
+// identify the edition, if none of them matches the title and author are used
+func ResolveBook(isbns []string, title, author string) (*models.Book, error) {
+	if OfflineMode() {
+		return nil, ErrOffline
 	}
 	baseImage := os.Getenv("IMAGE_URL")
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*30)
+	defer cancel()
 
-	for i := 0; i < len(response.Docs); i++ {
-		if response.Docs[i].CoverEditinoKey == "" {
+	for _, isbn := range isbns {
+		if isbn == "" {
 			continue
 		}
-		currentDoc := response.Docs[i]
-
-		authorName := "Unknown"
-		if len(currentDoc.AuthorName) > 0 {
-			authorName = strings.Join(currentDoc.AuthorName, ", ")
+		params := url.Values{}
+		params.Set("isbn", isbn)
+		found, err := firstWithCover(ctx, params, baseImage)
+		if err != nil {
+			return nil, err
+		}
+		if found != nil {
+			found.ISBN = isbn
+			return found, nil
 		}
+	}
+
+	if title == "" {
+		return nil, ErrBookNotFound
+	}
 
-		authorKey := ""
-		if len(currentDoc.AuthorKey) > 0 {
-			authorKey = strings.Join(currentDoc.AuthorKey, ", ")
+	params := url.Values{}
+	params.Set("title", normalizeString(title))
+	if author != "" {
+		params.Set("author", normalizeString(author))
+	}
+	found, err := firstWithCover(ctx, params, baseImage)
+	if err != nil {
+		return nil, err
+	}
+	if found == nil {
+		return nil, ErrBookNotFound
+	}
+
+	return found, nil
+}
+
+// The docs with a cover are preferred, the first doc is used if none of them has one
+func firstWithCover(ctx context.Context, params url.Values, baseImage string) (*models.Book, error) {
+	response, err := fetchDocs(ctx, openLibrarySearchURL()+"?"+params.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, currentDoc := range response.Docs {
+		if currentDoc.CoverEditinoKey == "" {
+			continue
 		}
+		book := docToBook(currentDoc, baseImage)
+		return &book, nil
+	}
+	for _, currentDoc := range response.Docs {
+		if bookKey(currentDoc) == "" {
+			continue
+		}
+		book := docToBook(currentDoc, baseImage)
+		return &book, nil
+	}
+
+	return nil, nil
+}
+
+func fetchDocs(ctx context.Context, url string) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Add("User-Agent", "bluefive.xyz:greenLibrary:andresdglez@gmail.com")
 
-		var tempBook = models.Book{
-			Title:       currentDoc.Title,
-			Author:      authorName,
-			Key:         currentDoc.CoverEditinoKey,
-			AuthorKey:   authorKey,
-			ReleaseYear: currentDoc.FirstPulishYear,
-			AVGRating:   currentDoc.AvgRating,
-			PageCount:   currentDoc.NumberOfPages,
-			CoverURL:    buildImageURL(currentDoc.CoverEditinoKey, baseImage),
+	resp, err := catalogClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("open library responded with status %d", resp.StatusCode)
+	}
+
+	var response response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return &response, nil
+}
+
+func docToBook(currentDoc doc, baseImage string) models.Book {
+	authorName := "Unknown"
+	if len(currentDoc.AuthorName) > 0 {
+		authorName = strings.Join(currentDoc.AuthorName, ", ")
+	}
+
+	authorKey := ""
+	if len(currentDoc.AuthorKey) > 0 {
+		authorKey = strings.Join(currentDoc.AuthorKey, ", ")
+	}
+
+	book := models.Book{
+		Title:        currentDoc.Title,
+		Author:       authorName,
+		Key:          bookKey(currentDoc),
+		AuthorKey:    authorKey,
+		ReleaseYear:  currentDoc.FirstPulishYear,
+		AVGRating:    currentDoc.AvgRating,
+		RatingsCount: currentDoc.RatingsCount,
+		PageCount:    currentDoc.NumberOfPages,
+		Covers:       models.Covers{},
+	}
+
+	//without a cover the book gets a placeholder once it is stored
+	if currentDoc.CoverEditinoKey != "" {
+		book.Covers = models.Covers{
+			models.RenditionThumbnail: {JPEG: buildImageURL(currentDoc.CoverEditinoKey, baseImage, "S")},
+			models.RenditionList:      {JPEG: buildImageURL(currentDoc.CoverEditinoKey, baseImage, "M")},
+			models.RenditionDetail:    {JPEG: buildImageURL(currentDoc.CoverEditinoKey, baseImage, "L")},
 		}
-		mu.Lock()
-		*target = append(*target, tempBook)
-		mu.Unlock()
 	}
 
-	fmt.Println(time.Since(start).Milliseconds())
-	return
+	return book
+}
+
+// The edition of the cover identifies the book, the docs without a cover use their first edition or the work
+func bookKey(currentDoc doc) string {
+	if currentDoc.CoverEditinoKey != "" {
+		return currentDoc.CoverEditinoKey
+	}
+	if len(currentDoc.EditionKey) > 0 {
+		return currentDoc.EditionKey[0]
+	}
+	return strings.TrimPrefix(currentDoc.Key, "/works/")
 }
 
-func buildImageURL(key, baseURL string) string {
-	return fmt.Sprint(baseURL, key, "-", "M", ".jpg")
+// open library offers the sizes S, M and L of every cover
+func buildImageURL(key, baseURL, size string) string {
+	return fmt.Sprint(baseURL, key, "-", size, ".jpg")
 }
 
 func normalizeString(original string) string {
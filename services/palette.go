@@ -0,0 +1,239 @@
+package services
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/disintegration/imaging"
+)
+
+const (
+	blurHashX = 4
+	blurHashY = 3
+	//the analysis is done over a small copy, the details don't change the result
+	analysisWidth = 64
+)
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Computes the BlurHash and the main colors of a cover so the clients can draw a placeholder before
+// the image loads
+func AnalyzeCover(img image.Image) models.CoverPalette {
+	small := img
+	if img.Bounds().Dx() > analysisWidth {
+		small = imaging.Resize(img, analysisWidth, 0, imaging.Box)
+	}
+
+	dominant, accent := mainColors(small)
+	return models.CoverPalette{
+		BlurHash:      BlurHash(small, blurHashX, blurHashY),
+		DominantColor: hexColor(dominant),
+		AccentColor:   hexColor(accent),
+	}
+}
+
+// Encodes the image following the reference implementation, https://github.com/woltapp/blurhash
+func BlurHash(img image.Image, xComponents, yComponents int) string {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width == 0 || height == 0 {
+		return ""
+	}
+
+	//the image is converted to linear RGB once instead of once per component
+	linear := make([][3]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			r, g, b, _ := img.At(bounds.Min.X+x, bounds.Min.Y+y).RGBA()
+			linear[y*width+x] = [3]float64{sRGBToLinear(r >> 8), sRGBToLinear(g >> 8), sRGBToLinear(b >> 8)}
+		}
+	}
+
+	factors := make([][3]float64, 0, xComponents*yComponents)
+	for j := 0; j < yComponents; j++ {
+		for i := 0; i < xComponents; i++ {
+			normalisation := 2.0
+			if i == 0 && j == 0 {
+				normalisation = 1
+			}
+			var factor [3]float64
+			for y := 0; y < height; y++ {
+				for x := 0; x < width; x++ {
+					basis := normalisation * math.Cos(math.Pi*float64(i)*float64(x)/float64(width)) *
+						math.Cos(math.Pi*float64(j)*float64(y)/float64(height))
+					pixel := linear[y*width+x]
+					factor[0] += basis * pixel[0]
+					factor[1] += basis * pixel[1]
+					factor[2] += basis * pixel[2]
+				}
+			}
+			scale := 1 / float64(width*height)
+			factors = append(factors, [3]float64{factor[0] * scale, factor[1] * scale, factor[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	sb.WriteString(encode83((xComponents-1)+(yComponents-1)*9, 1))
+
+	dc, ac := factors[0], factors[1:]
+	maximumValue := 1.0
+	if len(ac) > 0 {
+		actualMaximum := 0.0
+		for _, factor := range ac {
+			actualMaximum = math.Max(actualMaximum, math.Max(math.Abs(factor[0]), math.Max(math.Abs(factor[1]), math.Abs(factor[2]))))
+		}
+		quantisedMaximum := int(math.Max(0, math.Min(82, math.Floor(actualMaximum*166-0.5))))
+		maximumValue = float64(quantisedMaximum+1) / 166
+		sb.WriteString(encode83(quantisedMaximum, 1))
+	} else {
+		sb.WriteString(encode83(0, 1))
+	}
+
+	sb.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, factor := range ac {
+		quant := func(value float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(value/maximumValue, 0.5)*9+9.5))))
+		}
+		sb.WriteString(encode83(quant(factor[0])*19*19+quant(factor[1])*19+quant(factor[2]), 2))
+	}
+
+	return sb.String()
+}
+
+func encode83(value, length int) string {
+	result := make([]byte, length)
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		result[i-1] = base83Chars[digit]
+	}
+	return string(result)
+}
+
+func sRGBToLinear(value uint32) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
+
+type colorBucket struct {
+	index   int
+	count   int
+	r, g, b int
+}
+
+// the map of buckets has no order, so the ties go to the lowest index and the same cover always gets the same colors
+func (b *colorBucket) beats(other *colorBucket) bool {
+	return other == nil || b.count > other.count || (b.count == other.count && b.index < other.index)
+}
+
+// The dominant color is the most common one after reducing the image to 4 bits per channel. The accent is the
+// most common color that is saturated and far enough from the dominant one, if there is none the dominant
+// color is darkened or lightened
+func mainColors(img image.Image) (color.RGBA, color.RGBA) {
+	buckets := make(map[int]*colorBucket)
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			r, g, b = r>>8, g>>8, b>>8
+			index := int(r>>4)<<8 | int(g>>4)<<4 | int(b>>4)
+			bucket, ok := buckets[index]
+			if !ok {
+				bucket = &colorBucket{index: index}
+				buckets[index] = bucket
+			}
+			bucket.count++
+			bucket.r += int(r)
+			bucket.g += int(g)
+			bucket.b += int(b)
+		}
+	}
+
+	var dominantBucket *colorBucket
+	for _, bucket := range buckets {
+		if bucket.beats(dominantBucket) {
+			dominantBucket = bucket
+		}
+	}
+	if dominantBucket == nil {
+		return color.RGBA{A: 255}, color.RGBA{A: 255}
+	}
+	dominant := bucketColor(dominantBucket)
+
+	var accentBucket *colorBucket
+	for _, bucket := range buckets {
+		candidate := bucketColor(bucket)
+		if saturation(candidate) < 0.3 || colorDistance(candidate, dominant) < 100 {
+			continue
+		}
+		if bucket.beats(accentBucket) {
+			accentBucket = bucket
+		}
+	}
+	if accentBucket != nil {
+		return dominant, bucketColor(accentBucket)
+	}
+
+	if luminance(dominant) > 0.5 {
+		return dominant, shade(dominant, 0.6)
+	}
+	return dominant, lighten(dominant, 0.4)
+}
+
+func bucketColor(bucket *colorBucket) color.RGBA {
+	return color.RGBA{
+		R: uint8(bucket.r / bucket.count),
+		G: uint8(bucket.g / bucket.count),
+		B: uint8(bucket.b / bucket.count),
+		A: 255,
+	}
+}
+
+func saturation(c color.RGBA) float64 {
+	maxC := math.Max(float64(c.R), math.Max(float64(c.G), float64(c.B)))
+	minC := math.Min(float64(c.R), math.Min(float64(c.G), float64(c.B)))
+	if maxC == 0 {
+		return 0
+	}
+	return (maxC - minC) / maxC
+}
+
+func colorDistance(a, b color.RGBA) float64 {
+	dr, dg, db := float64(a.R)-float64(b.R), float64(a.G)-float64(b.G), float64(a.B)-float64(b.B)
+	return math.Sqrt(dr*dr + dg*dg + db*db)
+}
+
+func luminance(c color.RGBA) float64 {
+	return (0.2126*float64(c.R) + 0.7152*float64(c.G) + 0.0722*float64(c.B)) / 255
+}
+
+func lighten(c color.RGBA, factor float64) color.RGBA {
+	return color.RGBA{
+		R: uint8(float64(c.R) + (255-float64(c.R))*factor),
+		G: uint8(float64(c.G) + (255-float64(c.G))*factor),
+		B: uint8(float64(c.B) + (255-float64(c.B))*factor),
+		A: 255,
+	}
+}
+
+func hexColor(c color.RGBA) string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
@@ -0,0 +1,179 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	placeholderWidth  = 600
+	placeholderHeight = 900
+	placeholderMargin = 48
+)
+
+var (
+	fontsOnce  sync.Once
+	fontsErr   error
+	titleFont  *opentype.Font
+	authorFont *opentype.Font
+)
+
+func loadFonts() error {
+	fontsOnce.Do(func() {
+		titleFont, fontsErr = opentype.Parse(gobold.TTF)
+		if fontsErr != nil {
+			return
+		}
+		authorFont, fontsErr = opentype.Parse(goregular.TTF)
+	})
+	return fontsErr
+}
+
+// Generates a cover with the title and the author over a background color derived from the key of the book,
+// so the same book always gets the same cover. Returns a PNG that goes through the same pipeline as the
+// downloaded covers
+func RenderPlaceholder(title, author, key string) ([]byte, error) {
+	if err := loadFonts(); err != nil {
+		return nil, err
+	}
+
+	background := placeholderColor(key)
+	canvas := image.NewRGBA(image.Rect(0, 0, placeholderWidth, placeholderHeight))
+	draw.Draw(canvas, canvas.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+
+	//a darker band at the bottom holds the author
+	band := image.Rect(0, placeholderHeight*3/4, placeholderWidth, placeholderHeight)
+	draw.Draw(canvas, band, image.NewUniform(shade(background, 0.75)), image.Point{}, draw.Src)
+
+	titleFace, err := opentype.NewFace(titleFont, &opentype.FaceOptions{Size: 52, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer titleFace.Close()
+	authorFace, err := opentype.NewFace(authorFont, &opentype.FaceOptions{Size: 32, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, err
+	}
+	defer authorFace.Close()
+
+	textColor := image.NewUniform(color.White)
+	maxWidth := placeholderWidth - placeholderMargin*2
+
+	lines := wrapText(titleFace, title, maxWidth, 7)
+	lineHeight := titleFace.Metrics().Height.Ceil()
+	y := placeholderMargin + titleFace.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawCentered(canvas, titleFace, textColor, line, y)
+		y += lineHeight
+	}
+
+	authorLines := wrapText(authorFace, author, maxWidth, 2)
+	authorHeight := authorFace.Metrics().Height.Ceil()
+	y = band.Min.Y + (band.Dy()-authorHeight*len(authorLines))/2 + authorFace.Metrics().Ascent.Ceil()
+	for _, line := range authorLines {
+		drawCentered(canvas, authorFace, textColor, line, y)
+		y += authorHeight
+	}
+
+	buff := bytes.NewBuffer(nil)
+	if err := png.Encode(buff, canvas); err != nil {
+		return nil, err
+	}
+	return buff.Bytes(), nil
+}
+
+// the hue comes from the hash of the key, the saturation and lightness are fixed so the white text is readable
+func placeholderColor(key string) color.RGBA {
+	sum := sha256.Sum256([]byte(key))
+	hue := float64(uint16(sum[0])<<8|uint16(sum[1])) / 65536 * 360
+	return hslToRGB(hue, 0.45, 0.38)
+}
+
+func hslToRGB(hue, saturation, lightness float64) color.RGBA {
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	m := lightness - chroma/2
+
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g, b = chroma, x, 0
+	case hue < 120:
+		r, g, b = x, chroma, 0
+	case hue < 180:
+		r, g, b = 0, chroma, x
+	case hue < 240:
+		r, g, b = 0, x, chroma
+	case hue < 300:
+		r, g, b = x, 0, chroma
+	default:
+		r, g, b = chroma, 0, x
+	}
+	return color.RGBA{R: uint8((r + m) * 255), G: uint8((g + m) * 255), B: uint8((b + m) * 255), A: 255}
+}
+
+func shade(c color.RGBA, factor float64) color.RGBA {
+	return color.RGBA{R: uint8(float64(c.R) * factor), G: uint8(float64(c.G) * factor), B: uint8(float64(c.B) * factor), A: c.A}
+}
+
+// Splits the text in lines that fit the width, the last line ends with an ellipsis if the text doesn't fit
+func wrapText(face font.Face, text string, maxWidth, maxLines int) []string {
+	words := strings.Fields(text)
+	lines := make([]string, 0, maxLines)
+	current := ""
+
+	for i, word := range words {
+		candidate := strings.TrimSpace(current + " " + word)
+		if current == "" || font.MeasureString(face, candidate).Ceil() <= maxWidth {
+			current = candidate
+			continue
+		}
+		lines = append(lines, current)
+		current = word
+		if len(lines) == maxLines-1 {
+			current = strings.Join(words[i:], " ")
+			break
+		}
+	}
+	if current != "" {
+		lines = append(lines, current)
+	}
+
+	//the words longer than the cover and the last line are cut
+	for i, line := range lines {
+		if font.MeasureString(face, line).Ceil() <= maxWidth {
+			continue
+		}
+		runes := []rune(line)
+		for len(runes) > 0 && font.MeasureString(face, string(runes)+"…").Ceil() > maxWidth {
+			runes = runes[:len(runes)-1]
+		}
+		lines[i] = string(runes) + "…"
+	}
+
+	return lines
+}
+
+func drawCentered(canvas draw.Image, face font.Face, src image.Image, text string, y int) {
+	width := font.MeasureString(face, text)
+	drawer := &font.Drawer{
+		Dst:  canvas,
+		Src:  src,
+		Face: face,
+		Dot:  fixed.Point26_6{X: (fixed.I(placeholderWidth) - width) / 2, Y: fixed.I(y)},
+	}
+	drawer.DrawString(text)
+}
@@ -0,0 +1,26 @@
+package services
+
+// Distance between the positions of a collection when they are numbered again, new positions are taken
+// from the middle of two neighbors so a move only updates the moved entry
+const PositionGap = 1024.0
+
+// below this distance the midpoint loses precision and the collection must be numbered again
+const minPositionGap = 1e-6
+
+// Returns a position between prev and next, nil means the start or the end of the collection.
+// False when there is no room left between them
+func PositionBetween(prev, next *float64) (float64, bool) {
+	switch {
+	case prev == nil && next == nil:
+		return PositionGap, true
+	case prev == nil:
+		return *next - PositionGap, true
+	case next == nil:
+		return *prev + PositionGap, true
+	}
+
+	if *next-*prev < minPositionGap {
+		return 0, false
+	}
+	return *prev + (*next-*prev)/2, true
+}
@@ -0,0 +1,38 @@
+package services
+
+import "testing"
+
+func TestPositionBetween(t *testing.T) {
+	at := func(value float64) *float64 {
+		return &value
+	}
+
+	tests := []struct {
+		name   string
+		prev   *float64
+		next   *float64
+		want   float64
+		wantOK bool
+	}{
+		{"empty collection", nil, nil, PositionGap, true},
+		{"start", nil, at(1024), 0, true},
+		{"end", at(2048), nil, 2048 + PositionGap, true},
+		{"middle", at(1024), at(2048), 1536, true},
+		{"negative", at(-1024), at(0), -512, true},
+		{"close neighbors", at(1), at(1 + 1e-5), 1 + 0.5e-5, true},
+		{"no room", at(1), at(1 + 1e-7), 0, false},
+		{"same position", at(5), at(5), 0, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := PositionBetween(test.prev, test.next)
+			if ok != test.wantOK || got != test.want {
+				t.Errorf("PositionBetween() = %v, %v, want %v, %v", got, ok, test.want, test.wantOK)
+			}
+			if ok && test.prev != nil && test.next != nil && (got <= *test.prev || got >= *test.next) {
+				t.Errorf("PositionBetween() = %v, not between %v and %v", got, *test.prev, *test.next)
+			}
+		})
+	}
+}
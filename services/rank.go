@@ -0,0 +1,242 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+// A book found by one or more providers
+type SearchHit struct {
+	Book models.Book
+	//how many providers returned the book
+	Sources int
+	//best position of the book in the results of a provider, 0 is the first
+	Position int
+}
+
+// Joins the results of the providers, a book returned by several of them is kept once. Two books are the
+// same when they share the key, the ISBN or the title and first author once normalized. The stored copy of
+// a book is preferred and the empty fields are completed with the data of the other providers
+func MergeResults(results []ProviderResult) []SearchHit {
+	hits := make([]SearchHit, 0)
+	index := make(map[string]int)
+
+	for _, result := range results {
+		for position, book := range result.Books {
+			identities := bookIdentities(book)
+
+			found := -1
+			for _, identity := range identities {
+				if i, ok := index[identity]; ok {
+					found = i
+					break
+				}
+			}
+
+			if found < 0 {
+				hits = append(hits, SearchHit{Book: book, Sources: 1, Position: position})
+				found = len(hits) - 1
+			} else {
+				hit := &hits[found]
+				hit.Sources++
+				hit.Position = min(hit.Position, position)
+				if book.LocallyStored && !hit.Book.LocallyStored {
+					hit.Book = mergeBooks(book, hit.Book)
+				} else {
+					hit.Book = mergeBooks(hit.Book, book)
+				}
+			}
+
+			//the merged book can have identities that neither of the two had alone
+			for _, identity := range bookIdentities(hits[found].Book) {
+				if _, ok := index[identity]; !ok {
+					index[identity] = found
+				}
+			}
+		}
+	}
+
+	return hits
+}
+
+func bookIdentities(book models.Book) []string {
+	identities := make([]string, 0, 3)
+	if book.Key != "" {
+		identities = append(identities, "key:"+book.Key)
+	}
+	if book.ISBN != "" {
+		identities = append(identities, "isbn:"+book.ISBN)
+	}
+	//without author two books with the same title can't be told apart
+	title, author := searchText(mainTitle(book.Title)), searchText(firstAuthor(book.Author))
+	if title != "" && author != "" && author != "unknown" {
+		identities = append(identities, "text:"+title+"|"+author)
+	}
+	return identities
+}
+
+// Completes the empty fields of base with the ones of other
+func mergeBooks(base, other models.Book) models.Book {
+	if base.ISBN == "" {
+		base.ISBN = other.ISBN
+	}
+	if base.AuthorKey == "" {
+		base.AuthorKey = other.AuthorKey
+	}
+	if base.ReleaseYear == 0 {
+		base.ReleaseYear = other.ReleaseYear
+	}
+	if base.PageCount == 0 {
+		base.PageCount = other.PageCount
+	}
+	//the count belongs to the average, so both come from the same provider
+	if base.AVGRating == 0 {
+		base.AVGRating = other.AVGRating
+		base.RatingsCount = other.RatingsCount
+	}
+	if len(base.Covers) == 0 {
+		base.Covers = other.Covers
+	}
+	if (base.Author == "" || base.Author == "Unknown") && other.Author != "" {
+		base.Author = other.Author
+	}
+	base.LocallyStored = base.LocallyStored || other.LocallyStored
+	base.Shelved = base.Shelved || other.Shelved
+	return base
+}
+
+// Sorts the hits and returns their books. The books in the shelves of the user go first, followed by the
+// ones already stored and then by the rest. Inside each group the books are sorted by their score and the
+// ties are broken by title and key, so the same search always returns the same order
+func RankHits(query string, hits []SearchHit) []models.Book {
+	queryTokens := strings.Fields(searchText(query))
+
+	books := make([]models.Book, len(hits))
+	for i, hit := range hits {
+		books[i] = hit.Book
+		books[i].Score = scoreHit(queryTokens, hit)
+	}
+
+	sort.SliceStable(books, func(i, j int) bool {
+		if tierI, tierJ := rankTier(books[i]), rankTier(books[j]); tierI != tierJ {
+			return tierI < tierJ
+		}
+		if books[i].Score != books[j].Score {
+			return books[i].Score > books[j].Score
+		}
+		if books[i].Title != books[j].Title {
+			return books[i].Title < books[j].Title
+		}
+		return books[i].Key < books[j].Key
+	})
+
+	return books
+}
+
+func rankTier(book models.Book) int {
+	switch {
+	case book.Shelved:
+		return 0
+	case book.LocallyStored:
+		return 1
+	default:
+		return 2
+	}
+}
+
+// Weights of the parts of the score, they add up to 1
+const (
+	textWeight       = 0.6
+	popularityWeight = 0.15
+	agreementWeight  = 0.1
+	positionWeight   = 0.15
+)
+
+// Ratings needed for half the weight of the average, a 5 from a couple of readers says little
+const popularityRatings = 25
+
+// The score goes from 0 to 1. Most of it is how well the title and author match the query, the rest
+// comes from the rating weighted by its number of ratings, the number of providers that found the book
+// and the order of the providers
+func scoreHit(queryTokens []string, hit SearchHit) float64 {
+	text := textMatch(queryTokens, hit.Book)
+	ratings := float64(max(hit.Book.RatingsCount, 0))
+	popularity := math.Min(float64(hit.Book.AVGRating)/5, 1) * ratings / (ratings + popularityRatings)
+	agreement := math.Min(float64(hit.Sources-1), 2) / 2
+	position := 1 / float64(1+hit.Position)
+
+	score := textWeight*text + popularityWeight*popularity + agreementWeight*agreement + positionWeight*position
+	return math.Round(score*10000) / 10000
+}
+
+// Every token of the query found in the title counts more than one found in the author, a partial
+// word counts as a match since the query is usually being typed. Matching the whole title is a bonus
+func textMatch(queryTokens []string, book models.Book) float64 {
+	if len(queryTokens) == 0 {
+		return 0
+	}
+
+	title := searchText(book.Title)
+	titleTokens := strings.Fields(title)
+	authorTokens := strings.Fields(searchText(book.Author))
+
+	matched := 0.0
+	for _, token := range queryTokens {
+		switch {
+		case containsToken(titleTokens, token):
+			matched += 1
+		case containsToken(authorTokens, token):
+			matched += 0.7
+		}
+	}
+	match := matched / float64(len(queryTokens))
+
+	query := strings.Join(queryTokens, " ")
+	if title == query || searchText(mainTitle(book.Title)) == query {
+		match += 0.3
+	}
+	return math.Min(match, 1)
+}
+
+func containsToken(tokens []string, token string) bool {
+	for _, candidate := range tokens {
+		if strings.HasPrefix(candidate, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// Lowercase text without accents or punctuation, used to compare titles and authors of different providers
+func searchText(value string) string {
+	value = strings.ToLower(normalizeString(value))
+	var sb strings.Builder
+	space := false
+	for _, r := range value {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && sb.Len() > 0 {
+				sb.WriteRune(' ')
+			}
+			space = false
+			sb.WriteRune(r)
+		} else {
+			space = true
+		}
+	}
+	return sb.String()
+}
+
+// The title without the subtitle, some providers include it and others don't
+func mainTitle(title string) string {
+	main, _, _ := strings.Cut(title, ":")
+	return main
+}
+
+func firstAuthor(author string) string {
+	first, _, _ := strings.Cut(author, ",")
+	return first
+}
@@ -0,0 +1,186 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+func TestMergeResults(t *testing.T) {
+	tests := []struct {
+		name    string
+		results []ProviderResult
+		want    []SearchHit
+	}{
+		{
+			name: "same key",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL1", Title: "Dune"}, {Key: "OL2", Title: "Emma"}}},
+				{Books: []models.Book{{Key: "OL2", Title: "Emma", PageCount: 400}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "OL1", Title: "Dune"}, Sources: 1, Position: 0},
+				{Book: models.Book{Key: "OL2", Title: "Emma", PageCount: 400}, Sources: 2, Position: 0},
+			},
+		},
+		{
+			name: "same isbn",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL1", ISBN: "9780306406157"}}},
+				{Books: []models.Book{{Key: "g1", ISBN: "9780306406157", ReleaseYear: 1990}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "OL1", ISBN: "9780306406157", ReleaseYear: 1990}, Sources: 2},
+			},
+		},
+		{
+			name: "same title and first author without accents or subtitle",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL1", Title: "Cien años de soledad", Author: "Gabriel García Márquez"}}},
+				{Books: []models.Book{{Key: "g1", Title: "Cien Años de Soledad: Edición conmemorativa", Author: "Gabriel Garcia Marquez, Otro"}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "OL1", Title: "Cien años de soledad", Author: "Gabriel García Márquez"}, Sources: 2},
+			},
+		},
+		{
+			name: "unknown author is not enough",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL1", Title: "Poemas", Author: "Unknown"}}},
+				{Books: []models.Book{{Key: "g1", Title: "Poemas", Author: "Unknown"}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "OL1", Title: "Poemas", Author: "Unknown"}, Sources: 1},
+				{Book: models.Book{Key: "g1", Title: "Poemas", Author: "Unknown"}, Sources: 1},
+			},
+		},
+		{
+			name: "stored copy is preferred and completed",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL1", ISBN: "9780306406157", PageCount: 300, Author: "Ann"}}},
+				{Books: []models.Book{{Key: "local", ISBN: "9780306406157", Author: "Unknown", LocallyStored: true}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "local", ISBN: "9780306406157", PageCount: 300, Author: "Ann", LocallyStored: true}, Sources: 2},
+			},
+		},
+		{
+			name: "identities gained by merging",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL1"}}},
+				{Books: []models.Book{{Key: "OL1", ISBN: "9780306406157"}}},
+				{Books: []models.Book{{ISBN: "9780306406157"}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "OL1", ISBN: "9780306406157"}, Sources: 3},
+			},
+		},
+		{
+			name: "best position",
+			results: []ProviderResult{
+				{Books: []models.Book{{Key: "OL0"}, {Key: "OL1"}, {Key: "OL2"}}},
+				{Books: []models.Book{{Key: "OL2"}}},
+			},
+			want: []SearchHit{
+				{Book: models.Book{Key: "OL0"}, Sources: 1, Position: 0},
+				{Book: models.Book{Key: "OL1"}, Sources: 1, Position: 1},
+				{Book: models.Book{Key: "OL2"}, Sources: 2, Position: 0},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := MergeResults(test.results)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("MergeResults() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestRankHits(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		hits  []SearchHit
+		want  []string
+	}{
+		{
+			name:  "shelved, then stored, then the rest",
+			query: "dune",
+			hits: []SearchHit{
+				{Book: models.Book{Key: "remote", Title: "Dune"}, Sources: 3},
+				{Book: models.Book{Key: "stored", Title: "Dune Messiah", LocallyStored: true}, Sources: 1, Position: 4},
+				{Book: models.Book{Key: "shelved", Title: "Children of Dune", LocallyStored: true, Shelved: true}, Sources: 1, Position: 9},
+			},
+			want: []string{"shelved", "stored", "remote"},
+		},
+		{
+			name:  "title match beats author match",
+			query: "herbert",
+			hits: []SearchHit{
+				{Book: models.Book{Key: "author", Title: "Dune", Author: "Frank Herbert"}, Sources: 1},
+				{Book: models.Book{Key: "title", Title: "Herbert West", Author: "H. P. Lovecraft"}, Sources: 1},
+			},
+			want: []string{"title", "author"},
+		},
+		{
+			name:  "whole title bonus and prefixes",
+			query: "the hob",
+			hits: []SearchHit{
+				{Book: models.Book{Key: "partial", Title: "The Hobbit Companion"}, Sources: 1},
+				{Book: models.Book{Key: "none", Title: "Emma"}, Sources: 1},
+				{Book: models.Book{Key: "exact", Title: "The Hob"}, Sources: 1},
+			},
+			want: []string{"exact", "partial", "none"},
+		},
+		{
+			name:  "rating and agreement",
+			query: "emma",
+			hits: []SearchHit{
+				{Book: models.Book{Key: "plain", Title: "Emma"}, Sources: 1},
+				{Book: models.Book{Key: "rated", Title: "Emma", AVGRating: 4.5, RatingsCount: 100}, Sources: 1},
+				{Book: models.Book{Key: "agreed", Title: "Emma", AVGRating: 4.5, RatingsCount: 100}, Sources: 3},
+			},
+			want: []string{"agreed", "rated", "plain"},
+		},
+		{
+			name:  "rating weighted by its count",
+			query: "emma",
+			hits: []SearchHit{
+				{Book: models.Book{Key: "few", Title: "Emma", AVGRating: 5, RatingsCount: 2}, Sources: 1},
+				{Book: models.Book{Key: "many", Title: "Emma", AVGRating: 4.2, RatingsCount: 500}, Sources: 1},
+				{Book: models.Book{Key: "uncounted", Title: "Emma", AVGRating: 5}, Sources: 1},
+			},
+			want: []string{"many", "few", "uncounted"},
+		},
+		{
+			name:  "ties by title and key",
+			query: "",
+			hits: []SearchHit{
+				{Book: models.Book{Key: "b", Title: "Beta"}, Sources: 1},
+				{Book: models.Book{Key: "a2", Title: "Alpha"}, Sources: 1},
+				{Book: models.Book{Key: "a1", Title: "Alpha"}, Sources: 1},
+			},
+			want: []string{"a1", "a2", "b"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			books := RankHits(test.query, test.hits)
+			got := make([]string, len(books))
+			for i, book := range books {
+				got[i] = book.Key
+				if book.Score < 0 || book.Score > 1 {
+					t.Errorf("score of %s = %v, out of range", book.Key, book.Score)
+				}
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("RankHits() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
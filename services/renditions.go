@@ -0,0 +1,178 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+	"github.com/disintegration/imaging"
+	"github.com/gen2brain/webp"
+)
+
+const (
+	defaultRenditions  = "thumbnail:64,list:180,detail:400,original:0"
+	defaultJPEGQuality = 85
+	defaultWebPQuality = 80
+)
+
+// A size of the cover, a width of 0 keeps the size of the source image
+type RenditionSize struct {
+	Name  string
+	Width int
+}
+
+type RenditionConfig struct {
+	Sizes       []RenditionSize
+	JPEGQuality int
+	WebP        bool
+	WebPQuality int
+}
+
+// Reads the renditions from COVER_RENDITIONS (name:width separated by commas), the quality of the JPEGs
+// from COVER_JPEG_QUALITY and the ones of the WebPs from COVER_WEBP_QUALITY. COVER_WEBP=false disables the
+// WebP versions
+func LoadRenditionConfig() RenditionConfig {
+	config := RenditionConfig{
+		JPEGQuality: defaultJPEGQuality,
+		WebP:        os.Getenv("COVER_WEBP") != "false",
+		WebPQuality: defaultWebPQuality,
+	}
+
+	if quality, err := strconv.Atoi(os.Getenv("COVER_JPEG_QUALITY")); err == nil && quality > 0 && quality <= 100 {
+		config.JPEGQuality = quality
+	}
+	if quality, err := strconv.Atoi(os.Getenv("COVER_WEBP_QUALITY")); err == nil && quality > 0 && quality <= 100 {
+		config.WebPQuality = quality
+	}
+
+	sizes, err := parseRenditionSizes(os.Getenv("COVER_RENDITIONS"))
+	if err != nil || len(sizes) == 0 {
+		if err != nil {
+			PrintRedError(err.Error())
+		}
+		sizes, _ = parseRenditionSizes(defaultRenditions)
+	}
+	config.Sizes = sizes
+
+	return config
+}
+
+func parseRenditionSizes(value string) ([]RenditionSize, error) {
+	sizes := make([]RenditionSize, 0)
+	for _, item := range splitList(value) {
+		name, width, found := strings.Cut(item, ":")
+		if !found {
+			return nil, fmt.Errorf("invalid rendition %q, the format is name:width", item)
+		}
+		parsedWidth, err := strconv.Atoi(width)
+		if err != nil || parsedWidth < 0 {
+			return nil, fmt.Errorf("invalid width for the rendition %q", name)
+		}
+		sizes = append(sizes, RenditionSize{Name: strings.TrimSpace(name), Width: parsedWidth})
+	}
+	return sizes, nil
+}
+
+type EncodedImage struct {
+	Format      string
+	ContentType string
+	Data        []byte
+}
+
+type EncodedRendition struct {
+	Name   string
+	Width  int
+	Height int
+	Images []EncodedImage
+}
+
+type RenderedCover struct {
+	Renditions []EncodedRendition
+	Palette    models.CoverPalette
+}
+
+// Generates every rendition of the config from a single source image. The renditions are never wider
+// than the source, so small covers are not upscaled. The images are encoded again from the pixels, so the
+// metadata of the source (EXIF, GPS...) is dropped after applying its orientation
+func RenderCover(data []byte, config RenditionConfig) (*RenderedCover, error) {
+	//the downloaded covers are only limited by size, the uploads are already checked but it's cheap
+	if err := checkDimensions(data); err != nil {
+		return nil, err
+	}
+	source, err := imaging.Decode(bytes.NewReader(data), imaging.AutoOrientation(true))
+	if err != nil {
+		return nil, err
+	}
+	sourceWidth := source.Bounds().Dx()
+
+	renditions := make([]EncodedRendition, 0, len(config.Sizes))
+	for _, size := range config.Sizes {
+		resized := source
+		if size.Width > 0 && size.Width < sourceWidth {
+			resized = imaging.Resize(source, size.Width, 0, imaging.Lanczos)
+		}
+
+		rendition := EncodedRendition{
+			Name:   size.Name,
+			Width:  resized.Bounds().Dx(),
+			Height: resized.Bounds().Dy(),
+		}
+
+		buff := bytes.NewBuffer(nil)
+		if err := jpeg.Encode(buff, resized, &jpeg.Options{Quality: config.JPEGQuality}); err != nil {
+			return nil, err
+		}
+		rendition.Images = append(rendition.Images, EncodedImage{Format: "jpg", ContentType: "image/jpeg", Data: buff.Bytes()})
+
+		if config.WebP {
+			buff := bytes.NewBuffer(nil)
+			if err := webp.Encode(buff, resized, webp.Options{Quality: config.WebPQuality}); err != nil {
+				return nil, err
+			}
+			rendition.Images = append(rendition.Images, EncodedImage{Format: "webp", ContentType: "image/webp", Data: buff.Bytes()})
+		}
+
+		renditions = append(renditions, rendition)
+	}
+
+	return &RenderedCover{Renditions: renditions, Palette: AnalyzeCover(source)}, nil
+}
+
+// Prefixes of the keys of the images in the blob store
+const (
+	CoverPrefix  = "covers"
+	AvatarPrefix = "avatars"
+)
+
+// Sizes of the avatars of the users, the config of the covers is used for everything else
+var AvatarSizes = []RenditionSize{{Name: "small", Width: 64}, {Name: "medium", Width: 256}}
+
+// Stores the renditions of an image by the hash of their content and returns their URLs. A rendition
+// identical to one already stored, like the same cover used by two editions, is not written again
+func StoreRenditions(ctx context.Context, store BlobStore, prefix string, renditions []EncodedRendition) (models.Covers, error) {
+	covers := make(models.Covers, len(renditions))
+	for _, rendition := range renditions {
+		urls := models.Rendition{Width: rendition.Width}
+		for _, encoded := range rendition.Images {
+			key := ContentKey(prefix, encoded.Data, encoded.Format)
+			if err := PutIfMissing(ctx, store, key, encoded.Data, encoded.ContentType); err != nil {
+				return nil, err
+			}
+
+			switch encoded.Format {
+			case "jpg":
+				urls.JPEG = store.URL(key)
+			case "webp":
+				urls.WebP = store.URL(key)
+			}
+		}
+		covers[rendition.Name] = urls
+	}
+
+	return covers, nil
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"math"
+	"testing"
+
+	"github.com/gen2brain/webp"
+)
+
+// A gradient with noise, close enough to a photo for the lossy encoders
+func photoLike(width, height int) []byte {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		for y := 0; y < height; y++ {
+			noise := uint8(int(math.Sin(float64(x*y))*40) + 40)
+			img.Set(x, y, color.NRGBA{uint8(x) + noise, uint8(y) + noise/2, uint8(x+y) - noise, 255})
+		}
+	}
+	buff := bytes.NewBuffer(nil)
+	jpeg.Encode(buff, img, &jpeg.Options{Quality: 95})
+	return buff.Bytes()
+}
+
+func TestRenderCoverWebPQuality(t *testing.T) {
+	data := photoLike(600, 900)
+	sizes := []RenditionSize{{Name: "list", Width: 180}, {Name: "original", Width: 0}}
+
+	webpSize := func(quality int) []int {
+		cover, err := RenderCover(data, RenditionConfig{Sizes: sizes, JPEGQuality: 85, WebP: true, WebPQuality: quality})
+		if err != nil {
+			t.Fatal(err)
+		}
+		lengths := make([]int, 0, len(cover.Renditions))
+		for _, rendition := range cover.Renditions {
+			if len(rendition.Images) != 2 || rendition.Images[1].Format != "webp" {
+				t.Fatalf("rendition %s has %d images, want the JPEG and the WebP", rendition.Name, len(rendition.Images))
+			}
+			decoded, err := webp.Decode(bytes.NewReader(rendition.Images[1].Data))
+			if err != nil {
+				t.Fatalf("the WebP of %s can't be decoded: %v", rendition.Name, err)
+			}
+			if decoded.Bounds().Dx() != rendition.Width || decoded.Bounds().Dy() != rendition.Height {
+				t.Errorf("the WebP of %s is %v, want %dx%d", rendition.Name, decoded.Bounds().Size(), rendition.Width, rendition.Height)
+			}
+			lengths = append(lengths, len(rendition.Images[1].Data))
+		}
+		return lengths
+	}
+
+	low, high := webpSize(40), webpSize(95)
+	for i := range low {
+		if low[i] >= high[i] {
+			t.Errorf("rendition %s: quality 40 gives %d bytes and quality 95 gives %d", sizes[i].Name, low[i], high[i])
+		}
+	}
+
+	cover, err := RenderCover(data, RenditionConfig{Sizes: sizes, JPEGQuality: 85})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rendition := range cover.Renditions {
+		if len(rendition.Images) != 1 {
+			t.Errorf("rendition %s has %d images with the WebP disabled", rendition.Name, len(rendition.Images))
+		}
+	}
+}
+
+func TestLoadRenditionConfigWebPQuality(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+	}{
+		{"", defaultWebPQuality},
+		{"60", 60},
+		{"0", defaultWebPQuality},
+		{"101", defaultWebPQuality},
+		{"high", defaultWebPQuality},
+	}
+
+	for _, test := range tests {
+		t.Run(test.value, func(t *testing.T) {
+			t.Setenv("COVER_WEBP_QUALITY", test.value)
+			if got := LoadRenditionConfig().WebPQuality; got != test.want {
+				t.Errorf("WebPQuality = %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+const (
+	defaultCacheSize  = 1000
+	defaultCacheTTL   = time.Hour
+	defaultCacheStale = 24 * time.Hour
+	revalidateTimeout = 30 * time.Second
+	pruneInterval     = time.Hour
+)
+
+var ErrCacheMiss = errors.New("cache miss")
+
+// A response of a provider, Body is the JSON of the page
+type CachedResponse struct {
+	Body      []byte
+	FetchedAt time.Time
+}
+
+// Keeps the responses after a restart, the database implements it. Load returns ErrCacheMiss when the
+// key is not stored
+type ResponseStore interface {
+	Load(ctx context.Context, key string) (*CachedResponse, error)
+	Save(ctx context.Context, key string, response *CachedResponse) error
+	//removes the responses fetched before the time
+	Prune(ctx context.Context, before time.Time) error
+}
+
+type CacheConfig struct {
+	Size int
+	//while a response is younger than TTL the provider is not called
+	TTL time.Duration
+	//after the TTL, and until TTL+Stale, the response is still sent while a new one is fetched in the
+	//background. When the provider fails any response still kept is sent, even after TTL+Stale, until the
+	//LRU evicts it or the store prunes it
+	Stale time.Duration
+}
+
+// Reads CATALOG_CACHE_SIZE (entries in memory), CATALOG_CACHE_TTL and CATALOG_CACHE_STALE
+func LoadCacheConfig() CacheConfig {
+	config := CacheConfig{Size: defaultCacheSize, TTL: defaultCacheTTL, Stale: defaultCacheStale}
+	if size, err := strconv.Atoi(os.Getenv("CATALOG_CACHE_SIZE")); err == nil && size > 0 {
+		config.Size = size
+	}
+	if ttl, err := time.ParseDuration(os.Getenv("CATALOG_CACHE_TTL")); err == nil && ttl > 0 {
+		config.TTL = ttl
+	}
+	if stale, err := time.ParseDuration(os.Getenv("CATALOG_CACHE_STALE")); err == nil && stale >= 0 {
+		config.Stale = stale
+	}
+	return config
+}
+
+type CacheStats struct {
+	Entries       int     `json:"entries"`
+	Hits          int64   `json:"hits"`
+	StaleHits     int64   `json:"staleHits"`
+	Misses        int64   `json:"misses"`
+	Errors        int64   `json:"errors"`
+	StaleOnError  int64   `json:"staleOnError"`
+	Revalidations int64   `json:"revalidations"`
+	HitRatio      float64 `json:"hitRatio"`
+}
+
+// Cache of the responses of the providers shared by all of them, the keys include the name of the provider
+type ResponseCache struct {
+	config CacheConfig
+	memory *LRU[*CachedResponse]
+	store  ResponseStore
+
+	//keys being fetched in the background, so a stale entry is only revalidated once at a time
+	revalidating sync.Map
+	lastPrune    atomic.Int64
+
+	hits, staleHits, misses, errors, staleOnError, revalidations atomic.Int64
+}
+
+// The store is optional, without it the responses only live in memory
+func NewResponseCache(config CacheConfig, store ResponseStore) *ResponseCache {
+	return &ResponseCache{config: config, memory: NewLRU[*CachedResponse](config.Size), store: store}
+}
+
+func (c *ResponseCache) Stats() CacheStats {
+	stats := CacheStats{
+		Entries:       c.memory.Len(),
+		Hits:          c.hits.Load(),
+		StaleHits:     c.staleHits.Load(),
+		Misses:        c.misses.Load(),
+		Errors:        c.errors.Load(),
+		StaleOnError:  c.staleOnError.Load(),
+		Revalidations: c.revalidations.Load(),
+	}
+	if total := stats.Hits + stats.StaleHits + stats.Misses; total > 0 {
+		stats.HitRatio = float64(stats.Hits+stats.StaleHits) / float64(total)
+	}
+	return stats
+}
+
+// Wraps the provider so its responses go through the cache
+func (c *ResponseCache) Wrap(provider CatalogProvider) CatalogProvider {
+	return &cachedProvider{provider: provider, cache: c}
+}
+
+func (c *ResponseCache) lookup(ctx context.Context, key string) *CachedResponse {
+	if response, ok := c.memory.Get(key); ok {
+		return response
+	}
+	if c.store == nil {
+		return nil
+	}
+
+	response, err := c.store.Load(ctx, key)
+	if err != nil {
+		if !errors.Is(err, ErrCacheMiss) {
+			PrintRedError(err.Error())
+		}
+		return nil
+	}
+	c.memory.Put(key, response)
+	return response
+}
+
+func (c *ResponseCache) save(key string, response *CachedResponse) {
+	c.memory.Put(key, response)
+	if c.store == nil {
+		return
+	}
+
+	//the database is not in the way of the search
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+		defer cancel()
+		if err := c.store.Save(ctx, key, response); err != nil {
+			PrintRedError(err.Error())
+		}
+
+		now := time.Now()
+		last := c.lastPrune.Load()
+		if now.Sub(time.Unix(0, last)) > pruneInterval && c.lastPrune.CompareAndSwap(last, now.UnixNano()) {
+			if err := c.store.Prune(ctx, now.Add(-c.config.TTL-c.config.Stale)); err != nil {
+				PrintRedError(err.Error())
+			}
+		}
+	}()
+}
+
+type cachedProvider struct {
+	provider CatalogProvider
+	cache    *ResponseCache
+}
+
+func (p *cachedProvider) Name() string {
+	return p.provider.Name()
+}
+
+func (p *cachedProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	key := p.Name() + "|" + cacheKey(query)
+	cached := p.cache.lookup(ctx, key)
+
+	var age time.Duration
+	if cached != nil {
+		age = time.Since(cached.FetchedAt)
+	}
+	usable := cached != nil && age < p.cache.config.TTL+p.cache.config.Stale
+
+	if usable && age < p.cache.config.TTL {
+		if page, err := decodePage(cached); err == nil {
+			p.cache.hits.Add(1)
+			return page, nil
+		}
+	}
+
+	if usable {
+		if page, err := decodePage(cached); err == nil {
+			p.cache.staleHits.Add(1)
+			p.revalidate(key, query)
+			return page, nil
+		}
+	}
+
+	p.cache.misses.Add(1)
+	page, err := p.fetch(ctx, key, query)
+	if err != nil {
+		p.cache.errors.Add(1)
+		//during an outage an old answer is better than none, even one past TTL+Stale
+		if cached != nil {
+			if page, decodeErr := decodePage(cached); decodeErr == nil {
+				p.cache.staleOnError.Add(1)
+				return page, nil
+			}
+		}
+		return nil, err
+	}
+	return page, nil
+}
+
+func (p *cachedProvider) fetch(ctx context.Context, key string, query models.SearchQuery) (*CatalogPage, error) {
+	page, err := p.provider.Search(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := json.Marshal(page)
+	if err != nil {
+		return nil, err
+	}
+	p.cache.save(key, &CachedResponse{Body: body, FetchedAt: time.Now()})
+	return page, nil
+}
+
+func (p *cachedProvider) revalidate(key string, query models.SearchQuery) {
+	if _, running := p.cache.revalidating.LoadOrStore(key, true); running {
+		return
+	}
+	p.cache.revalidations.Add(1)
+
+	go func() {
+		defer p.cache.revalidating.Delete(key)
+		ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+		defer cancel()
+		if _, err := p.fetch(ctx, key, query); err != nil {
+			p.cache.errors.Add(1)
+			PrintRedError(err.Error())
+		}
+	}()
+}
+
+func decodePage(response *CachedResponse) (*CatalogPage, error) {
+	var page CatalogPage
+	if err := json.Unmarshal(response.Body, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
+
+// The same search written with other case or spacing has the same key. Anything else is kept as it is
+// sent to the providers, because quotes, a minus or an accent can change their results
+func cacheKey(query models.SearchQuery) string {
+	normalize := func(value string) string {
+		return strings.ToLower(strings.Join(strings.Fields(value), " "))
+	}
+	return strings.Join([]string{
+		normalize(query.Query),
+		normalize(query.Title),
+		normalize(query.Author),
+		query.ISBN,
+		strconv.Itoa(query.YearFrom),
+		strconv.Itoa(query.YearTo),
+		strings.ToLower(query.Language),
+		normalize(query.Subject),
+		strconv.Itoa(query.Page),
+		strconv.Itoa(query.Limit),
+	}, "|")
+}
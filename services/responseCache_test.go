@@ -0,0 +1,50 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+func TestCacheKey(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b models.SearchQuery
+		same bool
+	}{
+		{
+			name: "case and spacing",
+			a:    models.SearchQuery{Query: "El  Principito ", Page: 1},
+			b:    models.SearchQuery{Query: "el principito", Page: 1},
+			same: true,
+		},
+		{
+			name: "excluded word",
+			a:    models.SearchQuery{Query: "dune -messiah"},
+			b:    models.SearchQuery{Query: "dune messiah"},
+		},
+		{
+			name: "phrase",
+			a:    models.SearchQuery{Query: `"war and peace"`},
+			b:    models.SearchQuery{Query: "war and peace"},
+		},
+		{
+			name: "accents",
+			a:    models.SearchQuery{Author: "García"},
+			b:    models.SearchQuery{Author: "Garcia"},
+		},
+		{
+			name: "page",
+			a:    models.SearchQuery{Query: "dune", Page: 1},
+			b:    models.SearchQuery{Query: "dune", Page: 2},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if same := cacheKey(test.a) == cacheKey(test.b); same != test.same {
+				t.Errorf("same key = %v, want %v (%q, %q)", same, test.same, cacheKey(test.a), cacheKey(test.b))
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+var ErrInvalidRules = errors.New("invalid rules")
+
+// Limits of the rules of a smart collection, they are compiled to SQL on every read
+const (
+	maxRuleDepth = 4
+	maxRuleCount = 50
+)
+
+// Kinds of value of the fields of the rules
+const (
+	RuleNumber = "number"
+	RuleText   = "text"
+	RuleList   = "list"
+	RuleStatus = "status"
+)
+
+var ruleFields = map[string]string{
+	models.RuleRating:       RuleNumber,
+	models.RuleAVGRating:    RuleNumber,
+	models.RulePageCount:    RuleNumber,
+	models.RuleReleaseYear:  RuleNumber,
+	models.RuleStartedYear:  RuleNumber,
+	models.RuleFinishedYear: RuleNumber,
+	models.RuleTitle:        RuleText,
+	models.RuleAuthor:       RuleText,
+	models.RuleTag:          RuleList,
+	models.RuleMood:         RuleList,
+	models.RuleStatus:       RuleStatus,
+}
+
+var ruleOps = map[string][]string{
+	RuleNumber: {models.OpEq, models.OpNeq, models.OpGt, models.OpGte, models.OpLt, models.OpLte, models.OpIn},
+	RuleText:   {models.OpEq, models.OpNeq, models.OpContains, models.OpIn},
+	RuleList:   {models.OpEq, models.OpNeq, models.OpIn},
+	RuleStatus: {models.OpEq, models.OpNeq, models.OpIn},
+}
+
+var ruleStatuses = map[string]bool{
+	models.ShelfRead:    true,
+	models.ShelfReading: true,
+	models.ShelfToRead:  true,
+}
+
+// Returns the kind of value of a field of the rules, or an empty string if the field doesn't exist
+func RuleFieldKind(field string) string {
+	return ruleFields[field]
+}
+
+// Checks the fields, operators and values of the rules. The error wraps ErrInvalidRules
+func ValidateRules(rule *models.CollectionRule) error {
+	count := 0
+	return validateRule(rule, 1, &count)
+}
+
+func validateRule(rule *models.CollectionRule, depth int, count *int) error {
+	*count++
+	if *count > maxRuleCount {
+		return fmt.Errorf("%w: more than %d rules", ErrInvalidRules, maxRuleCount)
+	}
+
+	if rule.IsGroup() {
+		if depth > maxRuleDepth {
+			return fmt.Errorf("%w: more than %d nested groups", ErrInvalidRules, maxRuleDepth)
+		}
+		if rule.Match != models.MatchAll && rule.Match != models.MatchAny {
+			return fmt.Errorf("%w: unknown match %q", ErrInvalidRules, rule.Match)
+		}
+		for i := range rule.Rules {
+			if err := validateRule(&rule.Rules[i], depth+1, count); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	kind := RuleFieldKind(rule.Field)
+	if kind == "" {
+		return fmt.Errorf("%w: unknown field %q", ErrInvalidRules, rule.Field)
+	}
+	if rule.Match != "" || len(rule.Rules) > 0 {
+		return fmt.Errorf("%w: the rule of %s is not a group", ErrInvalidRules, rule.Field)
+	}
+	allowed := false
+	for _, op := range ruleOps[kind] {
+		allowed = allowed || op == rule.Op
+	}
+	if !allowed {
+		return fmt.Errorf("%w: %s doesn't support %q", ErrInvalidRules, rule.Field, rule.Op)
+	}
+
+	if rule.Op != models.OpIn {
+		if !validRuleValue(kind, rule.Value) {
+			return fmt.Errorf("%w: invalid value for %s", ErrInvalidRules, rule.Field)
+		}
+		return nil
+	}
+
+	values, ok := rule.Value.([]any)
+	if !ok || len(values) == 0 {
+		return fmt.Errorf("%w: %s in expects a list of values", ErrInvalidRules, rule.Field)
+	}
+	for _, value := range values {
+		if !validRuleValue(kind, value) {
+			return fmt.Errorf("%w: invalid value for %s", ErrInvalidRules, rule.Field)
+		}
+	}
+	return nil
+}
+
+// The values come from JSON, so the numbers are float64
+func validRuleValue(kind string, value any) bool {
+	switch kind {
+	case RuleNumber:
+		_, ok := value.(float64)
+		return ok
+	case RuleStatus:
+		status, ok := value.(string)
+		return ok && ruleStatuses[status]
+	default:
+		text, ok := value.(string)
+		return ok && text != ""
+	}
+}
@@ -0,0 +1,84 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+var ErrInvalidSort = errors.New("invalid sort")
+
+// more keys than this never change the order of a real collection
+const maxSortKeys = 5
+
+var sortFields = map[string]bool{
+	models.SortTitle:       true,
+	models.SortAuthor:      true,
+	models.SortDateAdded:   true,
+	models.SortRating:      true,
+	models.SortAVGRating:   true,
+	models.SortStarted:     true,
+	models.SortFinished:    true,
+	models.SortPageCount:   true,
+	models.SortReleaseYear: true,
+	models.SortManual:      true,
+	models.SortRandom:      true,
+}
+
+// Parses a list of fields separated by commas, a - before a field sorts it descending: "author,-rating".
+// An empty value gives the default sort. A random sort without seed gets a new one, which the client has to
+// send back to get the next pages in the same order
+func ParseSort(value, seed string) (models.BookSort, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return models.DefaultSort, nil
+	}
+
+	fields := strings.Split(value, ",")
+	if len(fields) > maxSortKeys {
+		return models.BookSort{}, fmt.Errorf("%w: more than %d fields", ErrInvalidSort, maxSortKeys)
+	}
+
+	sort := models.BookSort{Keys: make([]models.SortKey, 0, len(fields))}
+	for _, field := range fields {
+		field = strings.TrimSpace(field)
+		key := models.SortKey{}
+		if strings.HasPrefix(field, "-") {
+			key.Desc = true
+			field = field[1:]
+		} else {
+			field = strings.TrimPrefix(field, "+")
+		}
+		key.Field = field
+
+		if !sortFields[field] {
+			return models.BookSort{}, fmt.Errorf("%w: unknown field %q", ErrInvalidSort, field)
+		}
+		if sort.Has(field) {
+			return models.BookSort{}, fmt.Errorf("%w: %s is repeated", ErrInvalidSort, field)
+		}
+		//the positions only have a meaning in the order chosen by the user
+		if field == models.SortManual && key.Desc {
+			return models.BookSort{}, fmt.Errorf("%w: manual can't be descending", ErrInvalidSort)
+		}
+		sort.Keys = append(sort.Keys, key)
+	}
+
+	if sort.Has(models.SortRandom) {
+		if seed == "" {
+			sort.Seed = rand.Int63()
+		} else {
+			parsed, err := strconv.ParseInt(seed, 10, 64)
+			if err != nil {
+				return models.BookSort{}, fmt.Errorf("%w: the seed is not a number", ErrInvalidSort)
+			}
+			sort.Seed = parsed
+		}
+	}
+
+	return sort, nil
+}
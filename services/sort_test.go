@@ -0,0 +1,78 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"testing"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+func TestParseSort(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   string
+		seed    string
+		want    models.BookSort
+		wantErr bool
+	}{
+		{name: "default", value: "", want: models.DefaultSort},
+		{name: "blank", value: "  ", want: models.DefaultSort},
+		{name: "one field", value: "title", want: models.BookSort{Keys: []models.SortKey{{Field: models.SortTitle}}}},
+		{
+			name:  "several fields",
+			value: " author , -rating,+title",
+			want: models.BookSort{Keys: []models.SortKey{
+				{Field: models.SortAuthor}, {Field: models.SortRating, Desc: true}, {Field: models.SortTitle},
+			}},
+		},
+		{name: "manual", value: "manual", want: models.BookSort{Keys: []models.SortKey{{Field: models.SortManual}}}},
+		{
+			name:  "random with seed",
+			value: "random",
+			seed:  "42",
+			want:  models.BookSort{Keys: []models.SortKey{{Field: models.SortRandom}}, Seed: 42},
+		},
+		{name: "seed ignored without random", value: "title", seed: "x", want: models.BookSort{Keys: []models.SortKey{{Field: models.SortTitle}}}},
+		{name: "unknown field", value: "isbn", wantErr: true},
+		{name: "empty field", value: "title,", wantErr: true},
+		{name: "repeated field", value: "title,-title", wantErr: true},
+		{name: "descending manual", value: "-manual", wantErr: true},
+		{name: "too many fields", value: "title,author,rating,avgRating,pages,year", wantErr: true},
+		{name: "invalid seed", value: "random", seed: "x", wantErr: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := ParseSort(test.value, test.seed)
+			if test.wantErr {
+				if !errors.Is(err, ErrInvalidSort) {
+					t.Fatalf("ParseSort(%q) error = %v, want ErrInvalidSort", test.value, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseSort(%q) error = %v", test.value, err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("ParseSort(%q) = %+v, want %+v", test.value, got, test.want)
+			}
+		})
+	}
+}
+
+func TestParseSortRandomSeed(t *testing.T) {
+	sort, err := ParseSort("random,title", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	//the seed is sent back to the client, parsing it again gives the same sort
+	again, err := ParseSort("random,title", strconv.FormatInt(sort.Seed, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sort, again) {
+		t.Errorf("ParseSort() with the generated seed = %+v, want %+v", again, sort)
+	}
+}
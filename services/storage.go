@@ -0,0 +1,226 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var ErrBlobNotFound = errors.New("blob not found")
+
+type BlobInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Storage of the images. The keys use "/" as separator no matter the backend
+type BlobStore interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Exists(ctx context.Context, key string) (bool, error)
+	Delete(ctx context.Context, key string) error
+	// Sends every blob whose key starts with the prefix to the callback
+	List(ctx context.Context, prefix string, callback func(BlobInfo) error) error
+	// Public URL of a blob
+	URL(key string) string
+}
+
+// Creates the store selected by STORAGE_BACKEND, local if it is not set
+func NewBlobStoreFromEnv() (BlobStore, error) {
+	return NewBlobStore(os.Getenv("STORAGE_BACKEND"))
+}
+
+func NewBlobStore(backend string) (BlobStore, error) {
+	switch backend {
+	case "local", "":
+		return NewLocalStore(os.Getenv("IMG_DIR"), os.Getenv("IMG_URL")), nil
+	case "s3":
+		return NewS3StoreFromEnv()
+	default:
+		return nil, fmt.Errorf("unknown storage backend %q", backend)
+	}
+}
+
+// Key based on the SHA-256 of the content, so identical images are stored once. The first two characters
+// are used as a directory to keep the folders small
+func ContentKey(prefix string, data []byte, extension string) string {
+	sum := sha256.Sum256(data)
+	hash := hex.EncodeToString(sum[:])
+	return fmt.Sprintf("%s/%s/%s.%s", prefix, hash[:2], hash, extension)
+}
+
+// Prefixes of the keys written by StoreRenditions, the rest of the storage is not managed by the GC
+var ImagePrefixes = []string{CoverPrefix, AvatarPrefix}
+
+// Recovers the key of a stored image from its URL by its last segments, so it doesn't depend on the base
+// URL of the store. False for the URLs that don't end like a key of ContentKey, e.g. the remote covers
+func ImageKeyOf(url string) (string, bool) {
+	url, _, _ = strings.Cut(url, "?")
+	url, _, _ = strings.Cut(url, "#")
+	segments := strings.Split(url, "/")
+	if len(segments) < 3 {
+		return "", false
+	}
+	segments = segments[len(segments)-3:]
+	for _, prefix := range ImagePrefixes {
+		if segments[0] == prefix && len(segments[1]) == 2 && strings.HasPrefix(segments[2], segments[1]) {
+			return strings.Join(segments, "/"), true
+		}
+	}
+	return "", false
+}
+
+// Stores the blob unless a blob with the same key already exists. Only valid for content addressed keys.
+// An existing blob may be an orphan about to be collected, so its modification time is refreshed to give
+// it the grace period of the GC until the new reference is stored
+func PutIfMissing(ctx context.Context, store BlobStore, key string, data []byte, contentType string) error {
+	exists, err := store.Exists(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exists {
+		if toucher, ok := store.(blobToucher); ok {
+			return toucher.Touch(ctx, key)
+		}
+	}
+	return store.Put(ctx, key, data, contentType)
+}
+
+// stores that can refresh the modification time of a blob without writing it again
+type blobToucher interface {
+	Touch(ctx context.Context, key string) error
+}
+
+type LocalStore struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocalStore(dir, baseURL string) *LocalStore {
+	return &LocalStore{dir: dir, baseURL: baseURL}
+}
+
+func (s *LocalStore) path(key string) string {
+	return filepath.Join(s.dir, filepath.FromSlash(key))
+}
+
+func (s *LocalStore) Touch(ctx context.Context, key string) error {
+	now := time.Now()
+	return os.Chtimes(s.path(key), now, now)
+}
+
+// writes to a temporary file first so a reader never sees a half written image
+func (s *LocalStore) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	target := s.path(key)
+	if err := os.MkdirAll(filepath.Dir(target), 0777); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+func (s *LocalStore) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := os.ReadFile(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return data, err
+}
+
+func (s *LocalStore) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := os.Stat(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (s *LocalStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(s.path(key))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// The resized images are not blobs, so their directory is skipped when IMAGE_CACHE_DIR is inside IMG_DIR
+func (s *LocalStore) List(ctx context.Context, prefix string, callback func(BlobInfo) error) error {
+	cacheDir, _ := filepath.Abs(resizeCacheDir())
+	err := filepath.WalkDir(s.dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() {
+			if absolute, err := filepath.Abs(path); err == nil && absolute == cacheDir {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+
+		relative, err := filepath.Rel(s.dir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(relative)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		return callback(BlobInfo{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+func (s *LocalStore) URL(key string) string {
+	return s.baseURL + key
+}
+
+func ContentTypeByKey(key string) string {
+	switch strings.ToLower(filepath.Ext(key)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	default:
+		return "application/octet-stream"
+	}
+}
@@ -0,0 +1,65 @@
+package services
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestImageKeyOf(t *testing.T) {
+	tests := []struct {
+		url  string
+		want string
+		ok   bool
+	}{
+		{"https://host/images/covers/ab/abcdef.jpg", "covers/ab/abcdef.jpg", true},
+		{"https://cdn.example.com/covers/ab/abcdef.webp?v=2", "covers/ab/abcdef.webp", true},
+		{"http://old-host/img/avatars/0f/0f12.jpg", "avatars/0f/0f12.jpg", true},
+		{"covers/ab/abcdef.jpg", "covers/ab/abcdef.jpg", true},
+		{"https://covers.openlibrary.org/b/id/12345-L.jpg", "", false},
+		{"https://host/images/covers/ab/cdef.jpg", "", false},
+		{"https://host/images/placeholders/ab/abcdef.jpg", "", false},
+		{"abcdef.jpg", "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			got, ok := ImageKeyOf(test.url)
+			if got != test.want || ok != test.ok {
+				t.Errorf("ImageKeyOf(%q) = %q, %v, want %q, %v", test.url, got, ok, test.want, test.ok)
+			}
+		})
+	}
+}
+
+func TestLocalStoreListSkipsResizeCache(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("IMAGE_CACHE_DIR", filepath.Join(dir, "covers", "resized"))
+
+	store := NewLocalStore(dir, "https://host/images/")
+	ctx := context.Background()
+	for _, key := range []string{"covers/ab/abcd.jpg", "covers/resized/1234.jpg", "avatars/cd/cdef.jpg"} {
+		if err := store.Put(ctx, key, []byte("image"), "image/jpeg"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, "covers", "ab", ".upload-123"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := make([]string, 0)
+	err := store.List(ctx, "", func(blob BlobInfo) error {
+		keys = append(keys, blob.Key)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(keys)
+	if want := []string{"avatars/cd/cdef.jpg", "covers/ab/abcd.jpg"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("List() = %v, want %v", keys, want)
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+const storyGraphDateLayout = "2006/01/02"
+
+// Reads the CSV generated by The StoryGraph export (Manage Account > Export StoryGraph Library)
+func ParseStoryGraphCSV(r io.Reader) ([]models.ImportEntry, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	columns := indexColumns(header)
+	for _, required := range []string{"title", "read status"} {
+		if _, ok := columns[required]; !ok {
+			return nil, fmt.Errorf("missing column %q, the file is not a storygraph export", required)
+		}
+	}
+
+	entries := make([]models.ImportEntry, 0)
+	row := 1
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		row++
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %w", row, err)
+		}
+		get := func(name string) string {
+			return column(record, columns, name)
+		}
+
+		entry := models.ImportEntry{
+			Row:     row,
+			Title:   get("title"),
+			Author:  get("authors"),
+			ISBNs:   cleanISBNs(get("isbn/uid")),
+			Shelf:   strings.ToLower(get("read status")),
+			Comment: get("review"),
+			Tags:    splitList(get("tags")),
+			Moods:   splitList(get("moods")),
+		}
+
+		//the ratings go from 0.25 to 5 stars in quarters, anything else is rounded to the nearest quarter
+		entry.MyRating = float32(math.Round(float64(parseFloat(get("star rating")))*4) / 4)
+		entry.DateAdded = parseDate(storyGraphDateLayout, get("date added"))
+		entry.StartReading, entry.FinishReading = parseStoryGraphDates(get("dates read"))
+		if entry.FinishReading.IsZero() {
+			entry.FinishReading = parseDate(storyGraphDateLayout, get("last date read"))
+		}
+		if entry.Shelf != models.ShelfRead {
+			//only the read books keep the finish date, otherwise the app would take them as read
+			entry.FinishReading = time.Time{}
+		} else if entry.FinishReading.IsZero() {
+			entry.FinishReading = entry.DateAdded
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// the "Dates Read" column keeps every reading of the book as start-finish separated by commas,
+// only the last reading is kept since a book has a single entry in the library
+func parseStoryGraphDates(value string) (time.Time, time.Time) {
+	readings := splitList(value)
+	if len(readings) == 0 {
+		return time.Time{}, time.Time{}
+	}
+	start, finish, found := strings.Cut(readings[len(readings)-1], "-")
+	if !found {
+		return time.Time{}, parseDate(storyGraphDateLayout, strings.TrimSpace(start))
+	}
+	return parseDate(storyGraphDateLayout, strings.TrimSpace(start)), parseDate(storyGraphDateLayout, strings.TrimSpace(finish))
+}
+
+func splitList(value string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		v = strings.TrimSpace(v)
+		if v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
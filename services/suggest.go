@@ -0,0 +1,116 @@
+package services
+
+import (
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+const (
+	defaultSuggestTTL = 30 * time.Second
+	maxSuggestEntries = 5000
+	SuggestLimit      = 10
+	MinSuggestLength  = 2
+)
+
+type suggestEntry struct {
+	suggestions []models.Suggestion
+	//the query found less than the limit, so there's nothing else that starts with it
+	complete bool
+	expires  time.Time
+}
+
+// Keeps the suggestions of every user for a short time. While a word is typed every keystroke adds a
+// letter, so a complete answer for "dun" also answers "dune" without going to the database
+type SuggestCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[string]suggestEntry
+}
+
+// The time the suggestions are kept is SUGGEST_CACHE_TTL (30s by default), with 0 nothing is kept
+func NewSuggestCacheFromEnv() *SuggestCache {
+	ttl := defaultSuggestTTL
+	if value, err := time.ParseDuration(os.Getenv("SUGGEST_CACHE_TTL")); err == nil && value >= 0 {
+		ttl = value
+	}
+	return &SuggestCache{ttl: ttl, entries: make(map[string]suggestEntry)}
+}
+
+func (c *SuggestCache) TTL() time.Duration {
+	return c.ttl
+}
+
+// The text the suggestions are stored by, the same the search uses to compare
+func SuggestKey(query string) string {
+	return strings.Join(SearchWords(query), " ")
+}
+
+// Looks for the query or for a shorter version of it with a complete answer
+func (c *SuggestCache) Get(userID, query string) ([]models.Suggestion, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if entry, ok := c.entries[userID+"|"+query]; ok && now.Before(entry.expires) {
+		return entry.suggestions, true
+	}
+
+	runes := []rune(query)
+	for length := len(runes) - 1; length >= MinSuggestLength; length-- {
+		entry, ok := c.entries[userID+"|"+string(runes[:length])]
+		if !ok || !entry.complete || now.After(entry.expires) {
+			continue
+		}
+		return filterSuggestions(entry.suggestions, query), true
+	}
+
+	return nil, false
+}
+
+func (c *SuggestCache) Put(userID, query string, suggestions []models.Suggestion, complete bool) {
+	if c.ttl == 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if len(c.entries) >= maxSuggestEntries {
+		for key, entry := range c.entries {
+			if now.After(entry.expires) {
+				delete(c.entries, key)
+			}
+		}
+		//everything is recent, the whole cache is dropped instead of tracking the oldest entry
+		if len(c.entries) >= maxSuggestEntries {
+			c.entries = make(map[string]suggestEntry)
+		}
+	}
+
+	c.entries[userID+"|"+query] = suggestEntry{suggestions: suggestions, complete: complete, expires: now.Add(c.ttl)}
+}
+
+// Keeps the suggestions where every word of the query starts a word of their text
+func filterSuggestions(suggestions []models.Suggestion, query string) []models.Suggestion {
+	queryWords := strings.Fields(query)
+	filtered := make([]models.Suggestion, 0, len(suggestions))
+	for _, suggestion := range suggestions {
+		words := SearchWords(suggestion.Text + " " + suggestion.Author)
+		matches := true
+		for _, queryWord := range queryWords {
+			if !containsToken(words, queryWord) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, suggestion)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,106 @@
+package services
+
+import (
+	"strings"
+	"unicode"
+)
+
+type queryTerm struct {
+	words  []string
+	negate bool
+	phrase bool
+}
+
+// Turns what the user typed into the text of a tsquery, following the rules of websearch_to_tsquery:
+// the words are joined with AND, "quoted words" are a phrase, -word excludes it and "or" joins two terms
+// with OR. Every word outside a phrase also matches as a prefix, so a query can be used while it's being
+// typed. Anything that isn't a letter or a digit is dropped, so the result is always a valid tsquery and
+// it's empty when nothing can be searched
+func WebSearchQuery(input string) string {
+	terms := make([]queryTerm, 0)
+	operators := make([]string, 0)
+	pendingOr := false
+
+	addTerm := func(term queryTerm) {
+		if len(term.words) == 0 {
+			return
+		}
+		if len(terms) > 0 {
+			if pendingOr && !term.negate {
+				operators = append(operators, " | ")
+			} else {
+				operators = append(operators, " & ")
+			}
+		}
+		pendingOr = false
+		terms = append(terms, term)
+	}
+
+	runes := []rune(input)
+	for i := 0; i < len(runes); {
+		switch {
+		case unicode.IsSpace(runes[i]):
+			i++
+		case runes[i] == '"':
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			addTerm(queryTerm{words: queryWords(string(runes[i+1 : end])), phrase: true})
+			i = end + 1
+		default:
+			end := i
+			for end < len(runes) && !unicode.IsSpace(runes[end]) && runes[end] != '"' {
+				end++
+			}
+			word := string(runes[i:end])
+			i = end
+
+			if strings.EqualFold(word, "or") && len(terms) > 0 {
+				pendingOr = true
+				continue
+			}
+			negate := strings.HasPrefix(word, "-")
+			//a word like "spider-man" is kept as a phrase, like postgres does
+			words := queryWords(strings.TrimLeft(word, "-"))
+			addTerm(queryTerm{words: words, negate: negate, phrase: len(words) > 1})
+		}
+	}
+
+	//a query made only of exclusions matches nothing useful
+	positive := false
+	for _, term := range terms {
+		positive = positive || !term.negate
+	}
+	if !positive {
+		return ""
+	}
+
+	var sb strings.Builder
+	for i, term := range terms {
+		if i > 0 {
+			sb.WriteString(operators[i-1])
+		}
+		if term.negate {
+			sb.WriteString("!")
+		}
+		if term.phrase {
+			sb.WriteString("(" + strings.Join(term.words, " <-> ") + ")")
+		} else {
+			sb.WriteString(term.words[0] + ":*")
+		}
+	}
+	return sb.String()
+}
+
+// The words of the text in lowercase, without the characters that have a meaning in a tsquery
+func queryWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// The words of the text in lowercase and without accents, split like the database splits the titles
+func SearchWords(text string) []string {
+	return queryWords(normalizeString(text))
+}
@@ -0,0 +1,37 @@
+package services
+
+import "testing"
+
+func TestWebSearchQuery(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"", ""},
+		{"   ", ""},
+		{"Dune", "dune:*"},
+		{"dune messiah", "dune:* & messiah:*"},
+		{`"el principito"`, "(el <-> principito)"},
+		{`"el principito`, "(el <-> principito)"},
+		{"dune -messiah", "dune:* & !messiah:*"},
+		{"dune or hobbit", "dune:* | hobbit:*"},
+		{"dune OR hobbit emma", "dune:* | hobbit:* & emma:*"},
+		{"dune or -messiah", "dune:* & !messiah:*"},
+		{"or dune", "or:* & dune:*"},
+		{"spider-man", "(spider <-> man)"},
+		{"-dune", ""},
+		{"-dune -emma", ""},
+		{"Ñandú", "ñandú:*"},
+		{"dune:* & (x | !y)", "dune:* & x:* & y:*"},
+		{`'; DROP TABLE book; --`, "drop:* & table:* & book:*"},
+		{`"" dune`, "dune:*"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.input, func(t *testing.T) {
+			if got := WebSearchQuery(test.input); got != test.want {
+				t.Errorf("WebSearchQuery(%q) = %q, want %q", test.input, got, test.want)
+			}
+		})
+	}
+}
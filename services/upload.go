@@ -0,0 +1,80 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+const defaultMaxPixels = 40_000_000
+
+var (
+	ErrUnsupportedImage = errors.New("only JPEG, PNG and WebP images are supported")
+	ErrTooManyPixels    = errors.New("image exceeds the pixel limit")
+)
+
+var uploadTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/webp": true,
+}
+
+// the limit can be changed with UPLOAD_MAX_PIXELS
+func maxImagePixels() int {
+	limit, err := strconv.Atoi(os.Getenv("UPLOAD_MAX_PIXELS"))
+	if err != nil || limit <= 0 {
+		return defaultMaxPixels
+	}
+	return limit
+}
+
+// Limit of the body of a request that uploads an image, the image plus some room for the rest of the form.
+// The form is parsed before ReadUpload can check the size, so the body must be limited before
+func UploadBodyLimit() string {
+	return fmt.Sprintf("%dKiB", maxImageBytes()/1024+64)
+}
+
+// Reads an uploaded image without going over the size limit of the covers
+func ReadUpload(r io.Reader) ([]byte, error) {
+	limit := maxImageBytes()
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, ErrImageTooLarge
+	}
+	return data, nil
+}
+
+// Validates an uploaded image by its content, the name and the declared type are ignored. The dimensions are
+// read from the header before decoding the image, so a small file that expands to a huge bitmap is rejected
+func ValidateUpload(data []byte) error {
+	if !uploadTypes[http.DetectContentType(data)] {
+		return ErrUnsupportedImage
+	}
+
+	return checkDimensions(data)
+}
+
+// Reads the dimensions from the header of the image, so a small file that declares a huge canvas is rejected
+// before its bitmap is allocated
+func checkDimensions(data []byte) error {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrUnsupportedImage, err.Error())
+	}
+	if config.Width <= 0 || config.Height <= 0 {
+		return ErrUnsupportedImage
+	}
+	if config.Width*config.Height > maxImagePixels() {
+		return ErrTooManyPixels
+	}
+
+	return nil
+}
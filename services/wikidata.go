@@ -0,0 +1,171 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+const defaultWikidataURL = "https://query.wikidata.org/sparql"
+
+// Looks for literary works (Q7725634) and novels (Q8261) by their label. The authors are grouped so a
+// book with several of them is a single row
+const wikidataQuery = `SELECT ?item ?itemLabel (GROUP_CONCAT(DISTINCT ?authorLabel; separator=", ") AS ?authors)
+	(MIN(?date) AS ?published) (SAMPLE(?isbn) AS ?isbn13) (SAMPLE(?pages) AS ?pageCount) WHERE {
+	SERVICE wikibase:mwapi {
+		bd:serviceParam wikibase:endpoint "www.wikidata.org";
+			wikibase:api "EntitySearch";
+			mwapi:search %s;
+			mwapi:language "%s".
+		?item wikibase:apiOutputItem mwapi:item.
+	}
+	VALUES ?type { wd:Q7725634 wd:Q8261 wd:Q47461344 }
+	?item wdt:P31 ?type.%s
+	OPTIONAL { ?item wdt:P50 ?author. ?author rdfs:label ?authorLabel. FILTER(LANG(?authorLabel) = "%s") }
+	OPTIONAL { ?item wdt:P577 ?date. }
+	OPTIONAL { ?item wdt:P212 ?isbn. }
+	OPTIONAL { ?item wdt:P1104 ?pages. }
+	SERVICE wikibase:label { bd:serviceParam wikibase:language "%s,en". }
+}
+GROUP BY ?item ?itemLabel
+LIMIT %d OFFSET %d`
+
+// Items of the languages that can be used as a filter, a work is in a language by its "language of work" (P407)
+var wikidataLanguages = map[string]string{
+	"ar": "Q13955", "ca": "Q7026", "de": "Q188", "en": "Q1860", "es": "Q1321", "eu": "Q8752", "fr": "Q150",
+	"gl": "Q9307", "it": "Q652", "ja": "Q5287", "ko": "Q9176", "nl": "Q7411", "pl": "Q809", "pt": "Q5146",
+	"ru": "Q7737", "sv": "Q9027", "zh": "Q7850",
+}
+
+type wikidataResponse struct {
+	Results struct {
+		Bindings []map[string]struct {
+			Value string `json:"value"`
+		} `json:"bindings"`
+	} `json:"results"`
+}
+
+// The keys of the books of wikidata are prefixed so they can't collide with the ones of open library
+const wikidataKeyPrefix = "wikidata:"
+
+type WikidataProvider struct {
+	endpoint string
+	language string
+}
+
+// WIKIDATA_URL changes the SPARQL endpoint and WIKIDATA_LANGUAGE the language of the labels (es by default)
+func NewWikidataProvider() *WikidataProvider {
+	endpoint := os.Getenv("WIKIDATA_URL")
+	if endpoint == "" {
+		endpoint = defaultWikidataURL
+	}
+	language := os.Getenv("WIKIDATA_LANGUAGE")
+	if language == "" {
+		language = "es"
+	}
+	return &WikidataProvider{endpoint: endpoint, language: language}
+}
+
+func (p *WikidataProvider) Name() string {
+	return ProviderWikidata
+}
+
+// The works are found by their label, so a query without text returns nothing. The language filter keeps
+// the works written in it and its labels are preferred, a language without a known item returns nothing.
+// The other filters are applied to the page received and wikidata doesn't count the results, the total
+// only reaches the end of the current page
+func (p *WikidataProvider) Search(ctx context.Context, query models.SearchQuery) (*CatalogPage, error) {
+	text := strings.TrimSpace(query.Query + " " + query.Title)
+	if text == "" {
+		return &CatalogPage{Books: make([]models.Book, 0)}, nil
+	}
+
+	//the language goes inside the query, so only the codes of the map are accepted
+	language, filter := p.language, ""
+	if query.Language != "" {
+		item, ok := wikidataLanguages[strings.ToLower(query.Language)]
+		if !ok {
+			return &CatalogPage{Books: make([]models.Book, 0)}, nil
+		}
+		language = strings.ToLower(query.Language)
+		filter = "\n\t?item wdt:P407 wd:" + item + "."
+	}
+	sparql := fmt.Sprintf(wikidataQuery, sparqlString(text), language, filter, language, language, query.Limit, query.Offset())
+
+	params := url.Values{}
+	params.Set("query", sparql)
+	params.Set("format", "json")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", p.endpoint+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Add("Accept", "application/sparql-results+json")
+	//wikidata blocks the requests without a descriptive agent
+	req.Header.Add("User-Agent", "bluefive.xyz:greenLibrary:andresdglez@gmail.com")
+
+	resp, err := catalogClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("wikidata responded with status %d", resp.StatusCode)
+	}
+
+	var response wikidataResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	bindings := response.Results.Bindings
+	page := &CatalogPage{Books: make([]models.Book, 0, len(bindings)), Total: query.Offset() + len(bindings)}
+	for _, binding := range response.Results.Bindings {
+		id := binding["item"].Value[strings.LastIndex(binding["item"].Value, "/")+1:]
+		title := binding["itemLabel"].Value
+		//without a label in any language the label service returns the id
+		if id == "" || title == "" || title == id {
+			continue
+		}
+
+		book := models.Book{
+			Title:  title,
+			Author: binding["authors"].Value,
+			Key:    wikidataKeyPrefix + id,
+			ISBN:   strings.ReplaceAll(binding["isbn13"].Value, "-", ""),
+			Covers: models.Covers{},
+		}
+		if book.Author == "" {
+			book.Author = "Unknown"
+		}
+		//the dates are xsd:dateTime, like 1965-08-01T00:00:00Z
+		if published := binding["published"].Value; len(published) >= 4 {
+			if year, err := strconv.Atoi(published[:4]); err == nil {
+				book.ReleaseYear = year
+			}
+		}
+		if pages, err := strconv.Atoi(binding["pageCount"].Value); err == nil {
+			book.PageCount = pages
+		}
+
+		if !MatchesQuery(book, models.SearchQuery{Author: query.Author, ISBN: query.ISBN, YearFrom: query.YearFrom, YearTo: query.YearTo}) {
+			continue
+		}
+		page.Books = append(page.Books, book)
+	}
+
+	return page, nil
+}
+
+// Quotes a value to be used as a literal of a SPARQL query
+func sparqlString(value string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\r", `\r`)
+	return `"` + replacer.Replace(value) + `"`
+}
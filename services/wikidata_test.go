@@ -0,0 +1,64 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TheSgtPepper23/GreenLibrary/models"
+)
+
+func TestWikidataLanguageFilter(t *testing.T) {
+	tests := []struct {
+		name     string
+		language string
+		//parts of the query sent, empty when wikidata must not be called
+		want    []string
+		notWant []string
+	}{
+		{"without language", "", []string{`wikibase:language "es,en"`}, []string{"wdt:P407"}},
+		{"spanish", "es", []string{"?item wdt:P407 wd:Q1321.", `wikibase:language "es,en"`}, nil},
+		{"english in uppercase", "EN", []string{"?item wdt:P407 wd:Q1860.", `mwapi:language "en"`}, nil},
+		{"unknown language", "xx", nil, nil},
+		{"injection", `es" } #`, nil, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var sent string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				sent = r.URL.Query().Get("query")
+				w.Write([]byte(`{"results":{"bindings":[{"item":{"value":"http://www.wikidata.org/entity/Q1"},"itemLabel":{"value":"Cien años de soledad"}}]}}`))
+			}))
+			defer server.Close()
+
+			provider := &WikidataProvider{endpoint: server.URL, language: "es"}
+			page, err := provider.Search(context.Background(), models.SearchQuery{Query: "cien años", Language: test.language, Page: 1, Limit: 10})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if test.want == nil {
+				if sent != "" || len(page.Books) != 0 {
+					t.Errorf("the language %q is not supported but wikidata was searched", test.language)
+				}
+				return
+			}
+			for _, part := range test.want {
+				if !strings.Contains(sent, part) {
+					t.Errorf("the query doesn't contain %q:\n%s", part, sent)
+				}
+			}
+			for _, part := range test.notWant {
+				if strings.Contains(sent, part) {
+					t.Errorf("the query contains %q:\n%s", part, sent)
+				}
+			}
+			if len(page.Books) != 1 {
+				t.Errorf("Search() returned %d books, want 1", len(page.Books))
+			}
+		})
+	}
+}